@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"github.com/lekan/gophermart/internal/accrualstub"
+	"github.com/lekan/gophermart/internal/logger"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	logger.InitLogger()
+	log := logger.New()
+
+	runAddress := os.Getenv("RUN_ADDRESS")
+	if runAddress == "" {
+		runAddress = ":8000"
+	}
+
+	var (
+		scenarioPath string
+		percent      float64
+		base         float64
+		delay        time.Duration
+		invalid      string
+		unknown      string
+		rateLimit    int
+		retryAfter   time.Duration
+	)
+
+	flag.StringVar(&runAddress, "a", runAddress, "адрес и порт запуска сервиса")
+	flag.StringVar(&scenarioPath, "scenario", "", "файл со сценарием, заменяет правила из флагов")
+	flag.Float64Var(&percent, "percent", 10, "процент вознаграждения")
+	flag.Float64Var(&base, "base", 1000, "сумма заказа, от которой считается процент")
+	flag.DurationVar(&delay, "delay", 0, "задержка перед статусом PROCESSED")
+	flag.StringVar(&invalid, "invalid", "", "шаблон номеров со статусом INVALID")
+	flag.StringVar(&unknown, "unknown", "", "шаблон номеров, неизвестных системе (204)")
+	flag.IntVar(&rateLimit, "rate-limit", 0, "лимит запросов в минуту, 0 - без лимита")
+	flag.DurationVar(&retryAfter, "retry-after", 0, "значение Retry-After при превышении лимита")
+	flag.Parse()
+
+	var scenario accrualstub.Scenario
+	if scenarioPath != "" {
+		var err error
+		scenario, err = accrualstub.LoadScenario(scenarioPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can not load scenario")
+		}
+	} else {
+		if unknown != "" {
+			scenario.Rules = append(scenario.Rules, accrualstub.Rule{Pattern: unknown, Unknown: true})
+		}
+		if invalid != "" {
+			scenario.Rules = append(scenario.Rules, accrualstub.Rule{Pattern: invalid, Status: "INVALID", Delay: accrualstub.Duration(delay)})
+		}
+		scenario.Rules = append(scenario.Rules, accrualstub.Rule{Percent: percent, Base: base, Delay: accrualstub.Duration(delay)})
+	}
+	if scenario.RateLimit == 0 {
+		scenario.RateLimit = rateLimit
+	}
+	if scenario.RetryAfter == 0 {
+		scenario.RetryAfter = accrualstub.Duration(retryAfter)
+	}
+
+	server, err := accrualstub.New(scenario)
+	if err != nil {
+		log.Fatal().Err(err).Msg("wrong scenario")
+	}
+
+	log.Info().Msgf("accrual stub is up on %s...", runAddress)
+	err = http.ListenAndServe(runAddress, server.Handler())
+	if err != nil {
+		log.Fatal().Err(err).Msg("accrual stub error")
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"context"
 	"github.com/lekan/gophermart/internal/config"
-	"github.com/lekan/gophermart/internal/handlers"
+	"github.com/lekan/gophermart/internal/events"
+	"github.com/lekan/gophermart/internal/fraud"
 	"github.com/lekan/gophermart/internal/logger"
-	"github.com/lekan/gophermart/internal/mware"
+	"github.com/lekan/gophermart/internal/policy"
 	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/scheduler"
+	"github.com/lekan/gophermart/internal/webhooks"
 	"github.com/rs/zerolog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var log zerolog.Logger
@@ -25,20 +31,53 @@ func main() {
 		log.Fatal().Err(err)
 	}
 
-	router := chi.NewRouter()
-	router.Use(middleware.Logger)
-	router.Use(mware.CheckUser)
-	router.Use(mware.SetContext)
-
-	router.Route("/api/user", func(r chi.Router) {
-		r.Post("/register", handlers.Signup)
-		r.Post("/login", handlers.Signin)
-		r.Get("/balance", handlers.GetBalance)
-		r.Get("/withdrawals", handlers.GetWithdrawals)
-		r.Get("/orders", handlers.GetOrders)
-		r.Post("/orders", handlers.Orders)
-		r.Post("/balance/withdraw", handlers.Withdraw)
+	err = policy.Init(c.WithdrawalPolicyFile, policy.Policy{
+		Withdrawal: policy.Withdrawal{
+			MinSum:     c.WithdrawalMinSum,
+			MaxSum:     c.WithdrawalMaxSum,
+			DailyCap:   c.WithdrawalDailyCap,
+			MonthlyCap: c.WithdrawalMonthlyCap,
+			Cooldown:   policy.Duration(c.WithdrawalCooldown),
+		},
+		Fraud: fraud.DefaultRules(),
 	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("policy error")
+	}
+
+	// SIGHUP перечитывает файл политик
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if _, err := policy.Reload(); err != nil {
+				log.Err(err).Msg("policy reload error")
+				continue
+			}
+			log.Info().Msg("policy reloaded")
+		}
+	}()
+
+	go func() {
+		if err := events.Listen(context.Background(), c.DatabaseURI); err != nil {
+			log.Err(err).Msg("events listener error")
+		}
+	}()
+
+	go scheduler.Every(context.Background(), 5*time.Second, "webhooks", webhooks.Dispatch)
+	go scheduler.Every(context.Background(), c.AccrualPollInterval, "accrual poll", repo.PollStaleOrders)
+	go scheduler.Every(context.Background(), c.PointsExpireInterval, "points expiration", repo.ExpirePoints)
+	go scheduler.Every(context.Background(), time.Minute, "holds expiration", repo.ExpireHolds)
+	go scheduler.Every(context.Background(), c.TierRecalcInterval, "tiers", repo.RecalculateTiers)
+	go scheduler.Every(context.Background(), 30*time.Second, "tenants", repo.LoadTenants)
+	if c.EventsRetention > 0 {
+		go scheduler.Every(context.Background(), time.Hour, "events retention", repo.PurgeEvents)
+	}
+	if c.ReconcileInterval > 0 {
+		go scheduler.Every(context.Background(), c.ReconcileInterval, "reconciliation", repo.ScheduledReconciliation)
+	}
+
+	router := newRouter(c)
 
 	log.Info().Msg("server is up...")
 	err = http.ListenAndServe(c.RunAddress, router)
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/lekan/gophermart/internal/compress"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/handlers"
+	"github.com/lekan/gophermart/internal/mware"
+	"github.com/lekan/gophermart/internal/openapi"
+)
+
+// newRouter, the user API is checked against the OpenAPI document
+func newRouter(c *config.Config) chi.Router {
+	router := chi.NewRouter()
+	router.Use(middleware.Logger)
+	router.Use(compress.Decompress(c.DecompressMaxSize))
+	router.Use(compress.Compress(c.CompressMinSize))
+	router.Use(mware.Tenant)
+	router.Use(mware.CheckUser)
+	router.Use(mware.SetContext)
+
+	router.Get("/openapi.json", openapi.Serve)
+
+	router.Route("/api/user", func(r chi.Router) {
+		r.Use(openapi.Validate)
+		// в тестовом режиме ответы обработчиков сверяются с документом
+		if c.OpenAPIValidateResponses {
+			r.Use(openapi.ValidateResponses(openapi.LogMismatch))
+		}
+		r.Post("/register", handlers.Signup)
+		r.Post("/login", handlers.Signin)
+		r.Get("/balance", handlers.GetBalance)
+		r.Get("/withdrawals", handlers.GetWithdrawals)
+		r.Get("/orders", handlers.GetOrders)
+		r.With(mware.Velocity).Post("/orders", handlers.Orders)
+		r.With(mware.Velocity).Post("/orders/batch", handlers.OrdersBatch)
+		r.Post("/balance/withdraw", handlers.Withdraw)
+		r.Get("/events", handlers.Events)
+		r.Get("/balance/holds", handlers.GetHolds)
+		r.Post("/balance/holds", handlers.CreateHold)
+		r.Post("/balance/holds/{id}/capture", handlers.CaptureHold)
+		r.Post("/balance/holds/{id}/void", handlers.VoidHold)
+		r.Post("/balance/transfer", handlers.Transfer)
+		r.Get("/balance/transfers", handlers.GetTransfers)
+		r.Get("/transactions", handlers.GetTransactions)
+		r.Get("/statement", handlers.GetStatement)
+		r.Get("/profile", handlers.GetProfile)
+		r.Get("/referrals", handlers.GetReferrals)
+		r.Post("/vouchers/redeem", handlers.RedeemVoucher)
+		r.Get("/catalog", handlers.GetCatalog)
+		r.Post("/catalog/{id}/redeem", handlers.RedeemItem)
+		r.Get("/catalog/redemptions", handlers.GetRedemptions)
+	})
+
+	router.Post("/internal/accrual/callback", handlers.AccrualCallback)
+
+	// админ тенанта видит только свой тенант, тенантами и общими правилами управляет оператор с ADMIN_TOKEN
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(mware.CheckAdmin)
+			r.Get("/webhooks", handlers.GetWebhooks)
+			r.Post("/webhooks", handlers.CreateWebhook)
+			r.Delete("/webhooks/{id}", handlers.DeleteWebhook)
+			r.Get("/webhooks/deliveries", handlers.GetWebhookDeliveries)
+			r.Post("/webhooks/deliveries/{id}/replay", handlers.ReplayWebhookDelivery)
+			r.Get("/accrual/stats", handlers.GetAccrualStats)
+			r.Get("/reconciliations", handlers.GetReconciliations)
+			r.Post("/reconciliations", handlers.StartReconciliation)
+			r.Get("/reconciliations/{id}", handlers.GetReconciliation)
+			r.Get("/reconciliations/{id}/report", handlers.GetReconciliationReport)
+			r.Get("/adjustments", handlers.GetAdjustments)
+			r.Post("/adjustments/{id}/approve", handlers.ApproveAdjustment)
+			r.Post("/adjustments/{id}/reject", handlers.RejectAdjustment)
+			r.Get("/withdrawals/{order}/reversals", handlers.GetReversals)
+			r.Post("/withdrawals/{order}/reversals", handlers.ReverseWithdrawal)
+			r.Get("/statement", handlers.GetAdminStatement)
+			r.Get("/campaigns", handlers.GetCampaigns)
+			r.Post("/campaigns", handlers.CreateCampaign)
+			r.Get("/campaigns/{id}", handlers.GetCampaign)
+			r.Put("/campaigns/{id}", handlers.UpdateCampaign)
+			r.Delete("/campaigns/{id}", handlers.StopCampaign)
+			r.Get("/vouchers/batches", handlers.GetVoucherBatches)
+			r.Post("/vouchers/batches", handlers.CreateVoucherBatch)
+			r.Get("/vouchers/batches/{id}", handlers.GetVoucherBatch)
+			r.Get("/vouchers/batches/{id}/export", handlers.ExportVoucherBatch)
+			r.Get("/policy", handlers.GetPolicy)
+			r.Get("/fraud/flags", handlers.GetFraudFlags)
+			r.Post("/fraud/flags/{id}/clear", handlers.ClearFraudFlag)
+			r.Get("/fraud/reviews", handlers.GetReviewHolds)
+			r.Post("/fraud/reviews/{id}/approve", handlers.ApproveReview)
+			r.Post("/fraud/reviews/{id}/reject", handlers.RejectReview)
+			r.Get("/catalog", handlers.GetAdminCatalog)
+			r.Post("/catalog", handlers.CreateItem)
+			r.Put("/catalog/{id}", handlers.UpdateItem)
+			r.Delete("/catalog/{id}", handlers.DeactivateItem)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(mware.CheckOperator)
+			r.Post("/policy/reload", handlers.ReloadPolicy)
+			r.Get("/tenants", handlers.GetTenants)
+			r.Post("/tenants", handlers.CreateTenant)
+			r.Put("/tenants/{id}", handlers.UpdateTenant)
+		})
+	})
+
+	return router
+}
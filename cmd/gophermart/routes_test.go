@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/openapi"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestRoutesDocumented keeps the user routes and the OpenAPI document in step
+func TestRoutesDocumented(t *testing.T) {
+	var routes []string
+	err := chi.Walk(newRouter(&config.Config{}), func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/api/user/") {
+			routes = append(routes, method+" "+route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(routes)
+
+	documented := map[string]bool{}
+	for _, op := range openapi.Operations() {
+		documented[op] = true
+	}
+
+	for _, route := range routes {
+		if !documented[route] {
+			t.Errorf("%s is not in the document", route)
+		}
+		delete(documented, route)
+	}
+	for op := range documented {
+		t.Errorf("%s is documented but not routed", op)
+	}
+}
@@ -0,0 +1,136 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusInvalid    = "INVALID"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+)
+
+// ErrNotRegistered is returned when the accrual system doesn't know the order (204)
+var ErrNotRegistered = errors.New("accrual: order is not registered")
+
+// RateLimitError is returned when the accrual system answers 429
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual: too many requests, retry after %s", e.RetryAfter)
+}
+
+// ServerError is returned for 5xx and any other unexpected status
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("accrual: unexpected status %d", e.StatusCode)
+}
+
+// Order is the answer of GET /api/orders/{number}
+type Order struct {
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual,omitempty"`
+}
+
+// Final
+func (o Order) Final() bool {
+	return o.Status == StatusProcessed || o.Status == StatusInvalid
+}
+
+// Client of the accrual system
+type Client interface {
+	GetOrder(ctx context.Context, number string) (Order, error)
+}
+
+// ClientFunc lets a function be a Client, handy for fakes
+type ClientFunc func(ctx context.Context, number string) (Order, error)
+
+// GetOrder
+func (f ClientFunc) GetOrder(ctx context.Context, number string) (Order, error) {
+	return f(ctx, number)
+}
+
+// HTTPClient talks to the accrual system over HTTP
+type HTTPClient struct {
+	base *url.URL
+	http *http.Client
+}
+
+// NewHTTPClient
+func NewHTTPClient(address string, timeout time.Duration) (*HTTPClient, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = strings.TrimRight(base.Path, "/")
+
+	return &HTTPClient{
+		base: base,
+		http: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// GetOrder
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (Order, error) {
+	u := *c.base
+	u.Path = c.base.Path + "/api/orders/" + number
+	u.RawPath = c.base.Path + "/api/orders/" + url.PathEscape(number)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Order{}, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Order{}, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var order Order
+		if err = json.NewDecoder(res.Body).Decode(&order); err != nil {
+			return Order{}, fmt.Errorf("accrual: %w", err)
+		}
+		return order, nil
+	case http.StatusNoContent:
+		return Order{}, ErrNotRegistered
+	case http.StatusTooManyRequests:
+		return Order{}, &RateLimitError{RetryAfter: retryAfter(res.Header.Get("Retry-After"))}
+	default:
+		return Order{}, &ServerError{StatusCode: res.StatusCode}
+	}
+}
+
+// retryAfter understands seconds and HTTP dates, a minute is the default
+func retryAfter(v string) time.Duration {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return time.Minute
+}
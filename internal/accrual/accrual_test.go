@@ -0,0 +1,198 @@
+package accrual_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lekan/gophermart/internal/accrual"
+	"github.com/lekan/gophermart/internal/accrualstub"
+	"github.com/lekan/gophermart/internal/resilience"
+)
+
+// newStub starts the stand-in accrual service, the client must pass the same contract as against the real one
+func newStub(t *testing.T, scenario accrualstub.Scenario) *accrual.HTTPClient {
+	t.Helper()
+
+	stub, err := accrualstub.New(scenario)
+	if err != nil {
+		t.Fatalf("accrualstub.New() error = %v", err)
+	}
+	server := httptest.NewServer(stub.Handler())
+	t.Cleanup(server.Close)
+
+	client, err := accrual.NewHTTPClient(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	return client
+}
+
+func TestHTTPClient_GetOrder(t *testing.T) {
+	scenario := accrualstub.Scenario{
+		Rules: []accrualstub.Rule{
+			{Pattern: "^0", Unknown: true},
+			{Pattern: "^9", Status: "INVALID"},
+			{Pattern: "^1", Percent: 10, Base: 5000},
+		},
+		Orders: map[string][]accrualstub.Step{
+			"2377225624":       {{Code: http.StatusInternalServerError}},
+			"4561261212345467": {{Status: "PROCESSING"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		number  string
+		want    accrual.Order
+		wantErr func(err error) bool
+	}{
+		{
+			name:   "200 processed",
+			number: "12345678903",
+			want:   accrual.Order{Order: "12345678903", Status: accrual.StatusProcessed, Accrual: 500},
+		},
+		{
+			name:   "200 processing",
+			number: "4561261212345467",
+			want:   accrual.Order{Order: "4561261212345467", Status: accrual.StatusProcessing},
+		},
+		{
+			name:   "200 invalid",
+			number: "9278923470",
+			want:   accrual.Order{Order: "9278923470", Status: accrual.StatusInvalid},
+		},
+		{
+			name:   "204 not registered",
+			number: "0346436439",
+			wantErr: func(err error) bool {
+				return errors.Is(err, accrual.ErrNotRegistered)
+			},
+		},
+		{
+			name:   "500 server error",
+			number: "2377225624",
+			wantErr: func(err error) bool {
+				var serverErr *accrual.ServerError
+				return errors.As(err, &serverErr) && serverErr.StatusCode == http.StatusInternalServerError
+			},
+		},
+	}
+
+	client := newStub(t, scenario)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetOrder(context.Background(), tt.number)
+			if tt.wantErr != nil {
+				if !tt.wantErr(err) {
+					t.Errorf("GetOrder() error = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetOrder() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetOrder() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPClient_RateLimit(t *testing.T) {
+	client := newStub(t, accrualstub.Scenario{
+		Rules:      []accrualstub.Rule{{Percent: 10, Base: 1000}},
+		RateLimit:  1,
+		RetryAfter: accrualstub.Duration(60 * time.Second),
+	})
+
+	if _, err := client.GetOrder(context.Background(), "12345678903"); err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+
+	_, err := client.GetOrder(context.Background(), "12345678903")
+	var rateLimit *accrual.RateLimitError
+	if !errors.As(err, &rateLimit) {
+		t.Fatalf("GetOrder() error = %v, want RateLimitError", err)
+	}
+	if rateLimit.RetryAfter != 60*time.Second {
+		t.Errorf("RetryAfter = %s, want 60s", rateLimit.RetryAfter)
+	}
+}
+
+func TestGuarded(t *testing.T) {
+	calls := 0
+	fake := accrual.ClientFunc(func(ctx context.Context, number string) (accrual.Order, error) {
+		calls++
+		switch number {
+		case "429":
+			return accrual.Order{}, &accrual.RateLimitError{RetryAfter: time.Minute}
+		case "500":
+			return accrual.Order{}, &accrual.ServerError{StatusCode: http.StatusInternalServerError}
+		case "slow":
+			<-ctx.Done()
+			return accrual.Order{}, ctx.Err()
+		}
+		return accrual.Order{Order: number, Status: accrual.StatusProcessed}, nil
+	})
+
+	t.Run("breaker opens", func(t *testing.T) {
+		calls = 0
+		g := accrual.NewGuarded(fake, resilience.NewBreaker(2, time.Minute), resilience.NewBulkhead(1))
+		for i := 0; i < 2; i++ {
+			if _, err := g.GetOrder(context.Background(), "500"); err == nil {
+				t.Fatalf("GetOrder() must fail")
+			}
+		}
+		if _, err := g.GetOrder(context.Background(), "1"); !errors.Is(err, resilience.ErrOpen) {
+			t.Errorf("GetOrder() error = %v, want %v", err, resilience.ErrOpen)
+		}
+		if calls != 2 {
+			t.Errorf("calls = %d, want 2", calls)
+		}
+		if g.Stats().Breaker.State != resilience.Open {
+			t.Errorf("state = %v, want open", g.Stats().Breaker.State)
+		}
+	})
+
+	t.Run("canceled calls don't count", func(t *testing.T) {
+		calls = 0
+		g := accrual.NewGuarded(fake, resilience.NewBreaker(1, time.Minute), resilience.NewBulkhead(1))
+		for i := 0; i < 3; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			_, err := g.GetOrder(ctx, "slow")
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("GetOrder() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		}
+		if calls != 3 {
+			t.Errorf("calls = %d, want 3", calls)
+		}
+		if g.Stats().Breaker.State != resilience.Closed {
+			t.Errorf("state = %v, want closed", g.Stats().Breaker.State)
+		}
+	})
+
+	t.Run("rate limit pauses", func(t *testing.T) {
+		calls = 0
+		g := accrual.NewGuarded(fake, resilience.NewBreaker(2, time.Minute), resilience.NewBulkhead(1))
+		_, err := g.GetOrder(context.Background(), "429")
+		var rateLimit *accrual.RateLimitError
+		if !errors.As(err, &rateLimit) {
+			t.Fatalf("GetOrder() error = %v, want RateLimitError", err)
+		}
+		if _, err = g.GetOrder(context.Background(), "1"); !errors.As(err, &rateLimit) {
+			t.Errorf("paused GetOrder() error = %v, want RateLimitError", err)
+		}
+		if calls != 1 {
+			t.Errorf("calls = %d, want 1", calls)
+		}
+		if g.Stats().Breaker.State != resilience.Closed || g.Stats().PausedUntil == nil {
+			t.Errorf("Stats() = %+v", g.Stats())
+		}
+	})
+}
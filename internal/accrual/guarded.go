@@ -0,0 +1,93 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"github.com/lekan/gophermart/internal/resilience"
+	"sync"
+	"time"
+)
+
+// Stats
+type Stats struct {
+	Breaker     resilience.BreakerStats  `json:"breaker"`
+	Bulkhead    resilience.BulkheadStats `json:"bulkhead"`
+	PausedUntil *time.Time               `json:"paused_until,omitempty"`
+}
+
+// Guarded wraps a Client with a circuit breaker and a bulkhead and
+// stops calling it for the Retry-After time once it answers 429
+type Guarded struct {
+	next     Client
+	breaker  *resilience.Breaker
+	bulkhead *resilience.Bulkhead
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// NewGuarded
+func NewGuarded(next Client, breaker *resilience.Breaker, bulkhead *resilience.Bulkhead) *Guarded {
+	return &Guarded{
+		next:     next,
+		breaker:  breaker,
+		bulkhead: bulkhead,
+		now:      time.Now,
+	}
+}
+
+// GetOrder, server and transport errors count as breaker failures, a call given up by the caller doesn't count
+func (g *Guarded) GetOrder(ctx context.Context, number string) (Order, error) {
+	g.mu.Lock()
+	paused := g.pausedUntil.Sub(g.now())
+	g.mu.Unlock()
+	if paused > 0 {
+		return Order{}, &RateLimitError{RetryAfter: paused}
+	}
+
+	if err := g.bulkhead.Acquire(ctx); err != nil {
+		return Order{}, err
+	}
+	defer g.bulkhead.Release()
+
+	if err := g.breaker.Allow(); err != nil {
+		return Order{}, err
+	}
+
+	order, err := g.next.GetOrder(ctx, number)
+
+	var rateLimit *RateLimitError
+	switch {
+	case err == nil, errors.Is(err, ErrNotRegistered):
+		g.breaker.Success()
+	case ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+		g.breaker.Cancel()
+	case errors.As(err, &rateLimit):
+		g.breaker.Success()
+		g.mu.Lock()
+		g.pausedUntil = g.now().Add(rateLimit.RetryAfter)
+		g.mu.Unlock()
+	default:
+		g.breaker.Failure()
+	}
+
+	return order, err
+}
+
+// Stats
+func (g *Guarded) Stats() Stats {
+	stats := Stats{
+		Breaker:  g.breaker.Stats(),
+		Bulkhead: g.bulkhead.Stats(),
+	}
+
+	g.mu.Lock()
+	if g.pausedUntil.After(g.now()) {
+		pausedUntil := g.pausedUntil
+		stats.PausedUntil = &pausedUntil
+	}
+	g.mu.Unlock()
+
+	return stats
+}
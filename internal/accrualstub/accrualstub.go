@@ -0,0 +1,225 @@
+package accrualstub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Duration is time.Duration written as "5s" in scenario files
+type Duration time.Duration
+
+// UnmarshalJSON
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule describes how orders matching Pattern are answered, the first matching rule wins
+type Rule struct {
+	Pattern string   `json:"pattern"`
+	Unknown bool     `json:"unknown"`
+	Status  string   `json:"status"`
+	Percent float64  `json:"percent"`
+	Base    float64  `json:"base"`
+	Delay   Duration `json:"delay"`
+
+	re *regexp.Regexp
+}
+
+// Step is a scripted answer for an order, it is used once After has passed since the first request
+type Step struct {
+	After   Duration `json:"after"`
+	Code    int      `json:"code"`
+	Status  string   `json:"status"`
+	Accrual float64  `json:"accrual"`
+}
+
+// Scenario
+type Scenario struct {
+	Rules      []Rule            `json:"rules"`
+	Orders     map[string][]Step `json:"orders"`
+	RateLimit  int               `json:"rate_limit"`
+	RetryAfter Duration          `json:"retry_after"`
+}
+
+// Order is the answer of GET /api/orders/{number}
+type Order struct {
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual,omitempty"`
+}
+
+// LoadScenario
+func LoadScenario(path string) (Scenario, error) {
+	var s Scenario
+
+	f, err := os.Open(path)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&s)
+	return s, err
+}
+
+// Server answers like the accrual system
+type Server struct {
+	mu          sync.Mutex
+	scenario    Scenario
+	firstSeen   map[string]time.Time
+	windowStart time.Time
+	windowCount int
+
+	now func() time.Time
+}
+
+// New
+func New(scenario Scenario) (*Server, error) {
+	for i := range scenario.Rules {
+		re, err := regexp.Compile(scenario.Rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		scenario.Rules[i].re = re
+
+		switch scenario.Rules[i].Status {
+		case "":
+			scenario.Rules[i].Status = "PROCESSED"
+		case "PROCESSED", "INVALID":
+		default:
+			return nil, fmt.Errorf("rule %d: unknown status %s", i, scenario.Rules[i].Status)
+		}
+	}
+
+	for number, steps := range scenario.Orders {
+		if len(steps) == 0 {
+			return nil, errors.New("no steps for order " + number)
+		}
+	}
+
+	return &Server{
+		scenario:  scenario,
+		firstSeen: make(map[string]time.Time),
+		now:       time.Now,
+	}, nil
+}
+
+// Handler
+func (s *Server) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/api/orders/{number}", s.getOrder)
+	return router
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	now := s.now()
+
+	if retryAfter, limited := s.limit(now); limited {
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", s.scenario.RateLimit)
+		return
+	}
+
+	first, ok := s.firstSeen[number]
+	if !ok {
+		first = now
+		s.firstSeen[number] = now
+	}
+	code, order := s.answer(number, now.Sub(first))
+	s.mu.Unlock()
+
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(order)
+}
+
+// limit counts the request in the current minute, must be called with the lock held
+func (s *Server) limit(now time.Time) (time.Duration, bool) {
+	if s.scenario.RateLimit <= 0 {
+		return 0, false
+	}
+
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+
+	s.windowCount++
+	if s.windowCount <= s.scenario.RateLimit {
+		return 0, false
+	}
+
+	if s.scenario.RetryAfter > 0 {
+		return time.Duration(s.scenario.RetryAfter), true
+	}
+	return s.windowStart.Add(time.Minute).Sub(now), true
+}
+
+// answer returns the status code and the order after elapsed since the first request
+func (s *Server) answer(number string, elapsed time.Duration) (int, Order) {
+	if steps, ok := s.scenario.Orders[number]; ok {
+		step := steps[0]
+		for _, v := range steps[1:] {
+			if elapsed >= time.Duration(v.After) {
+				step = v
+			}
+		}
+		if step.Code != 0 && step.Code != http.StatusOK {
+			return step.Code, Order{}
+		}
+		return http.StatusOK, Order{Order: number, Status: step.Status, Accrual: step.Accrual}
+	}
+
+	for _, rule := range s.scenario.Rules {
+		if !rule.re.MatchString(number) {
+			continue
+		}
+
+		if rule.Unknown {
+			return http.StatusNoContent, Order{}
+		}
+
+		if elapsed < time.Duration(rule.Delay) {
+			if elapsed == 0 {
+				return http.StatusOK, Order{Order: number, Status: "REGISTERED"}
+			}
+			return http.StatusOK, Order{Order: number, Status: "PROCESSING"}
+		}
+
+		if rule.Status == "INVALID" {
+			return http.StatusOK, Order{Order: number, Status: "INVALID"}
+		}
+		accrual := math.Round(rule.Base*rule.Percent) / 100
+		return http.StatusOK, Order{Order: number, Status: "PROCESSED", Accrual: accrual}
+	}
+
+	return http.StatusNoContent, Order{}
+}
@@ -0,0 +1,127 @@
+package accrualstub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type request struct {
+	number  string
+	after   time.Duration
+	code    int
+	status  string
+	accrual float64
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario Scenario
+		requests []request
+	}{
+		{
+			name: "processed test #1",
+			scenario: Scenario{
+				Rules: []Rule{{Percent: 10, Base: 1000}},
+			},
+			requests: []request{
+				{number: "12345678903", code: http.StatusOK, status: "PROCESSED", accrual: 100},
+			},
+		},
+		{
+			name: "delay test #1",
+			scenario: Scenario{
+				Rules: []Rule{{Percent: 5, Base: 500, Delay: Duration(2 * time.Second)}},
+			},
+			requests: []request{
+				{number: "12345678903", code: http.StatusOK, status: "REGISTERED"},
+				{number: "12345678903", after: time.Second, code: http.StatusOK, status: "PROCESSING"},
+				{number: "12345678903", after: 2 * time.Second, code: http.StatusOK, status: "PROCESSED", accrual: 25},
+			},
+		},
+		{
+			name: "invalid and unknown test #1",
+			scenario: Scenario{
+				Rules: []Rule{
+					{Pattern: "^0", Unknown: true},
+					{Pattern: "^9", Status: "INVALID"},
+				},
+			},
+			requests: []request{
+				{number: "0123", code: http.StatusNoContent},
+				{number: "9278923470", code: http.StatusOK, status: "INVALID"},
+				{number: "12345678903", code: http.StatusNoContent},
+			},
+		},
+		{
+			name: "rate limit test #1",
+			scenario: Scenario{
+				Rules:      []Rule{{Percent: 10, Base: 1000}},
+				RateLimit:  2,
+				RetryAfter: Duration(30 * time.Second),
+			},
+			requests: []request{
+				{number: "12345678903", code: http.StatusOK, status: "PROCESSED", accrual: 100},
+				{number: "12345678903", code: http.StatusOK, status: "PROCESSED", accrual: 100},
+				{number: "12345678903", code: http.StatusTooManyRequests},
+				{number: "12345678903", after: time.Minute, code: http.StatusOK, status: "PROCESSED", accrual: 100},
+			},
+		},
+		{
+			name: "scripted test #1",
+			scenario: Scenario{
+				Orders: map[string][]Step{
+					"12345678903": {
+						{Status: "REGISTERED"},
+						{After: Duration(time.Second), Code: http.StatusInternalServerError},
+						{After: Duration(2 * time.Second), Status: "PROCESSED", Accrual: 729.98},
+					},
+				},
+			},
+			requests: []request{
+				{number: "12345678903", code: http.StatusOK, status: "REGISTERED"},
+				{number: "12345678903", after: time.Second, code: http.StatusInternalServerError},
+				{number: "12345678903", after: 3 * time.Second, code: http.StatusOK, status: "PROCESSED", accrual: 729.98},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.scenario)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			start := time.Now()
+			now := start
+			s.now = func() time.Time { return now }
+			handler := s.Handler()
+
+			for _, req := range tt.requests {
+				now = start.Add(req.after)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders/"+req.number, nil))
+
+				if rec.Code != req.code {
+					t.Fatalf("after %s got code %d, want %d", req.after, rec.Code, req.code)
+				}
+				if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "30" {
+					t.Errorf("Retry-After = %s, want 30", rec.Header().Get("Retry-After"))
+				}
+				if rec.Code != http.StatusOK {
+					continue
+				}
+
+				var got Order
+				if err = json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("json error = %v", err)
+				}
+				if got.Order != req.number || got.Status != req.status || got.Accrual != req.accrual {
+					t.Errorf("after %s got %+v, want %s %v", req.after, got, req.status, req.accrual)
+				}
+			}
+		})
+	}
+}
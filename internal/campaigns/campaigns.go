@@ -0,0 +1,140 @@
+// Package campaigns decides which promotional campaigns reward a processed order and how much
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Campaign, zero rules and rewards are not applied
+type Campaign struct {
+	ID          int       `json:"id" db:"campaign_id"`
+	Name        string    `json:"name" db:"name"`
+	StartsAt    time.Time `json:"starts_at" db:"starts_at"`
+	EndsAt      time.Time `json:"ends_at" db:"ends_at"`
+	FirstOrder  bool      `json:"first_order,omitempty" db:"first_order"`
+	Tiers       string    `json:"tiers,omitempty" db:"tiers"`
+	OrderPrefix string    `json:"order_prefix,omitempty" db:"order_prefix"`
+	Multiplier  float32   `json:"multiplier,omitempty" db:"multiplier"`
+	FixedBonus  float32   `json:"fixed_bonus,omitempty" db:"fixed_bonus"`
+	CapPerUser  float32   `json:"cap_per_user,omitempty" db:"cap_per_user"`
+	Active      bool      `json:"active" db:"active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Order is what the rules look at
+type Order struct {
+	Number      string
+	Accrual     float32
+	Tier        string
+	FirstOrder  bool
+	ProcessedAt time.Time
+}
+
+// Bonus
+type Bonus struct {
+	CampaignID int     `json:"campaign_id"`
+	Campaign   string  `json:"campaign"`
+	Amount     float32 `json:"amount"`
+}
+
+// Validate
+func (c Campaign) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("name is required")
+	case c.StartsAt.IsZero() || c.EndsAt.IsZero() || !c.StartsAt.Before(c.EndsAt):
+		return errors.New("wrong campaign window")
+	case c.Multiplier != 0 && c.Multiplier < 1:
+		return errors.New("multiplier must be at least 1")
+	case c.FixedBonus < 0 || c.CapPerUser < 0:
+		return errors.New("bonus and cap can not be negative")
+	case c.Multiplier <= 1 && c.FixedBonus == 0:
+		return errors.New("campaign gives nothing")
+	}
+	return nil
+}
+
+// Eligible checks the window and the rules of the campaign
+func (c Campaign) Eligible(o Order) bool {
+	if !c.Active || o.ProcessedAt.Before(c.StartsAt) || !o.ProcessedAt.Before(c.EndsAt) {
+		return false
+	}
+	if c.FirstOrder && !o.FirstOrder {
+		return false
+	}
+	if c.OrderPrefix != "" && !strings.HasPrefix(o.Number, c.OrderPrefix) {
+		return false
+	}
+	if c.Tiers != "" && !contains(c.Tiers, o.Tier) {
+		return false
+	}
+	return true
+}
+
+// Reward for the order, awarded is what the user already got from the campaign
+func (c Campaign) Reward(o Order, awarded float32) float32 {
+	var bonus float32
+	if c.Multiplier > 1 {
+		bonus += o.Accrual * (c.Multiplier - 1)
+	}
+	bonus += c.FixedBonus
+
+	if c.CapPerUser > 0 && awarded+bonus > c.CapPerUser {
+		bonus = c.CapPerUser - awarded
+	}
+	if bonus < 0 {
+		return 0
+	}
+	return bonus
+}
+
+// Evaluate returns the bonuses of all campaigns for the order, awarded is keyed by campaign id
+func Evaluate(list []Campaign, o Order, awarded map[int]float32) []Bonus {
+	res := []Bonus{}
+	for _, c := range list {
+		if !c.Eligible(o) {
+			continue
+		}
+		if amount := c.Reward(o, awarded[c.ID]); amount > 0 {
+			res = append(res, Bonus{CampaignID: c.ID, Campaign: c.Name, Amount: amount})
+		}
+	}
+	return res
+}
+
+// Store keeps the awarded bonuses, Award returns false if the campaign already rewarded the order
+type Store interface {
+	Award(ctx context.Context, b Bonus) (bool, error)
+	Credit(ctx context.Context, b Bonus) error
+}
+
+// Apply credits the bonuses of the order that were not awarded before, so a repeated
+// processing of the same order gives nothing
+func Apply(ctx context.Context, s Store, bonuses []Bonus) error {
+	for _, b := range bonuses {
+		ok, err := s.Award(ctx, b)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = s.Credit(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contains looks for the tier in a comma separated list
+func contains(list string, tier string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == tier {
+			return true
+		}
+	}
+	return false
+}
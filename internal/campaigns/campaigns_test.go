@@ -0,0 +1,145 @@
+package campaigns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var (
+	start = time.Date(2022, 5, 7, 0, 0, 0, 0, time.UTC)
+	end   = start.Add(48 * time.Hour)
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Campaign
+		wantErr bool
+	}{
+		{
+			name: "success test #1",
+			c:    Campaign{Name: "double weekend", StartsAt: start, EndsAt: end, Multiplier: 2},
+		},
+		{
+			name: "success test #2",
+			c:    Campaign{Name: "first order", StartsAt: start, EndsAt: end, FirstOrder: true, FixedBonus: 100},
+		},
+		{
+			name:    "no name",
+			c:       Campaign{StartsAt: start, EndsAt: end, Multiplier: 2},
+			wantErr: true,
+		},
+		{
+			name:    "window ends before start",
+			c:       Campaign{Name: "x", StartsAt: end, EndsAt: start, Multiplier: 2},
+			wantErr: true,
+		},
+		{
+			name:    "multiplier below one",
+			c:       Campaign{Name: "x", StartsAt: start, EndsAt: end, Multiplier: 0.5},
+			wantErr: true,
+		},
+		{
+			name:    "no reward",
+			c:       Campaign{Name: "x", StartsAt: start, EndsAt: end},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	list := []Campaign{
+		{ID: 1, Name: "double weekend", StartsAt: start, EndsAt: end, Multiplier: 2, CapPerUser: 150, Active: true},
+		{ID: 2, Name: "first order", StartsAt: start, EndsAt: end, FirstOrder: true, FixedBonus: 100, Active: true},
+		{ID: 3, Name: "gold 79", StartsAt: start, EndsAt: end, Tiers: "silver, gold", OrderPrefix: "79", FixedBonus: 10, Active: true},
+		{ID: 4, Name: "stopped", StartsAt: start, EndsAt: end, FixedBonus: 1000},
+	}
+
+	tests := []struct {
+		name    string
+		order   Order
+		awarded map[int]float32
+		want    map[int]float32
+	}{
+		{
+			name:  "outside the window",
+			order: Order{Number: "79927398713", Accrual: 100, Tier: "gold", FirstOrder: true, ProcessedAt: end},
+			want:  map[int]float32{},
+		},
+		{
+			name:  "first order in the window",
+			order: Order{Number: "4561261212345467", Accrual: 100, Tier: "bronze", FirstOrder: true, ProcessedAt: start},
+			want:  map[int]float32{1: 100, 2: 100},
+		},
+		{
+			name:    "cap reached partly",
+			order:   Order{Number: "79927398713", Accrual: 100, Tier: "gold", ProcessedAt: start.Add(time.Hour)},
+			awarded: map[int]float32{1: 100},
+			want:    map[int]float32{1: 50, 3: 10},
+		},
+		{
+			name:    "cap reached",
+			order:   Order{Number: "79927398713", Accrual: 100, Tier: "bronze", ProcessedAt: start.Add(time.Hour)},
+			awarded: map[int]float32{1: 150},
+			want:    map[int]float32{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(list, tt.order, tt.awarded)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Evaluate() = %+v, want %v", got, tt.want)
+			}
+			for _, b := range got {
+				if b.Amount != tt.want[b.CampaignID] {
+					t.Errorf("bonus of campaign %d = %v, want %v", b.CampaignID, b.Amount, tt.want[b.CampaignID])
+				}
+			}
+		})
+	}
+}
+
+// memStore keeps one bonus per campaign like the unique key of campaign_bonuses
+type memStore struct {
+	awarded  map[int]bool
+	credited float32
+}
+
+func (s *memStore) Award(ctx context.Context, b Bonus) (bool, error) {
+	if s.awarded[b.CampaignID] {
+		return false, nil
+	}
+	s.awarded[b.CampaignID] = true
+	return true, nil
+}
+
+func (s *memStore) Credit(ctx context.Context, b Bonus) error {
+	s.credited += b.Amount
+	return nil
+}
+
+func TestApply_SameOrderTwice(t *testing.T) {
+	list := []Campaign{
+		{ID: 1, Name: "double weekend", StartsAt: start, EndsAt: end, Multiplier: 2, Active: true},
+		{ID: 2, Name: "fixed", StartsAt: start, EndsAt: end, FixedBonus: 10, Active: true},
+	}
+	order := Order{Number: "79927398713", Accrual: 100, ProcessedAt: start}
+	s := &memStore{awarded: map[int]bool{}}
+
+	for i := 0; i < 2; i++ {
+		if err := Apply(context.Background(), s, Evaluate(list, order, nil)); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	if s.credited != 110 {
+		t.Errorf("credited = %v, want 110", s.credited)
+	}
+}
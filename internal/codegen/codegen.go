@@ -0,0 +1,37 @@
+// Package codegen makes random human friendly codes for referrals and vouchers
+package codegen
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Alphabet has no 0, O, 1, I and L, they are easy to mix up when typed by hand
+const Alphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// New returns a random code of n characters
+func New(n int) (string, error) {
+	if n <= 0 {
+		return "", errors.New("code length must be positive")
+	}
+
+	max := big.NewInt(int64(len(Alphabet)))
+	var b strings.Builder
+	b.Grow(n)
+	for i := 0; i < n; i++ {
+		k, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(Alphabet[k.Int64()])
+	}
+	return b.String(), nil
+}
+
+// Normalize makes a typed code comparable: upper case, without spaces and dashes
+func Normalize(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
@@ -0,0 +1,62 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{name: "success test #1", n: 8},
+		{name: "success test #2", n: 1},
+		{name: "zero length", n: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != tt.n {
+				t.Errorf("New() = %s, want %d characters", got, tt.n)
+			}
+			for _, r := range got {
+				if !strings.ContainsRune(Alphabet, r) {
+					t.Errorf("New() = %s has %q out of the alphabet", got, r)
+				}
+			}
+		})
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		code, _ := New(10)
+		if seen[code] {
+			t.Fatalf("code %s repeated", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcd-efgh", want: "ABCDEFGH"},
+		{code: " AB CD ", want: "ABCD"},
+		{code: "ABCD", want: "ABCD"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.code); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package compress decodes gzip and deflate request bodies and compresses responses
+// for clients that accept it
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/lekan/gophermart/internal/problem"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// ErrTooLarge is returned when the decoded body is over the limit
+var ErrTooLarge = errors.New("decompressed body is too large")
+
+// Decompress replaces a gzip or deflate encoded body with the decoded one.
+// The body is decoded at once, so a body over maxSize after decoding gets 413
+// before the handler reads anything; an unknown encoding gets 415.
+func Decompress(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if encoding != encodingGzip && encoding != encodingDeflate {
+				problem.Status(w, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			body, err := decode(r.Body, encoding, maxSize)
+			r.Body.Close()
+			if errors.Is(err, ErrTooLarge) {
+				problem.Status(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				problem.Status(w, http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			r.Header.Del("Content-Encoding")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decode reads at most maxSize decoded bytes, deflate is zlib wrapped as HTTP says,
+// raw deflate streams of older clients are accepted too
+func decode(body io.Reader, encoding string, maxSize int64) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	var reader io.ReadCloser
+	switch encoding {
+	case encodingGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(raw))
+	default:
+		reader, err = zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(raw)), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	res, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(res)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return res, nil
+}
+
+// Compress encodes responses of at least minSize bytes with the best encoding
+// the client accepts. Event streams and responses that are already encoded pass as they are.
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &writer{ResponseWriter: w, encoding: encoding, minSize: minSize, status: http.StatusOK}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// Negotiate picks gzip or deflate from Accept-Encoding, an empty result means no compression
+func Negotiate(accept string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		if name, q := parseCoding(part); name != "" {
+			weights[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	// при равном весе предпочитаем gzip
+	for _, name := range []string{encodingGzip, encodingDeflate} {
+		q, ok := weights[name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// parseCoding
+func parseCoding(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	name := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64)
+		if err != nil {
+			return name, 0
+		}
+		q = v
+	}
+	return name, q
+}
+
+// writer holds the response back until it is clear whether it reaches the threshold
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *writer) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+	cw.status = status
+	// без тела или для потока событий решать нечего
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified || !cw.compressible() {
+		_ = cw.decide(false)
+	}
+}
+
+func (cw *writer) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.decide(false)
+		} else {
+			cw.buf = append(cw.buf, b...)
+			if len(cw.buf) < cw.minSize {
+				return len(b), nil
+			}
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// compressible
+func (cw *writer) compressible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	return !strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
+
+// decide sends the headers and the held back bytes, with or without compression
+func (cw *writer) decide(compress bool) error {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if cw.encoding == encodingGzip {
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.enc = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what is written so far, a stream that flushes before the threshold is compressed
+func (cw *writer) Flush() {
+	if !cw.decided {
+		_ = cw.decide(cw.compressible() && len(cw.buf) > 0)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack
+func (cw *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	return h.Hijack()
+}
+
+// Close finishes the response, a short one goes out uncompressed
+func (cw *writer) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return data
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoding   string
+		header     string
+		body       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "plain body",
+			encoding:   "",
+			header:     "",
+			body:       []byte("12345678903"),
+			wantStatus: http.StatusOK,
+			wantBody:   "12345678903",
+		},
+		{
+			name:       "gzip order number",
+			encoding:   "gzip",
+			header:     "gzip",
+			body:       []byte("12345678903"),
+			wantStatus: http.StatusOK,
+			wantBody:   "12345678903",
+		},
+		{
+			name:       "deflate json",
+			encoding:   "deflate",
+			header:     "deflate",
+			body:       []byte(`{"order":"2377225624","sum":751}`),
+			wantStatus: http.StatusOK,
+			wantBody:   `{"order":"2377225624","sum":751}`,
+		},
+		{
+			name:       "raw deflate",
+			encoding:   "raw",
+			header:     "Deflate",
+			body:       []byte("12345678903"),
+			wantStatus: http.StatusOK,
+			wantBody:   "12345678903",
+		},
+		{
+			name:       "bomb",
+			encoding:   "gzip",
+			header:     "gzip",
+			body:       bytes.Repeat([]byte("0"), 10<<20),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "broken gzip",
+			encoding:   "",
+			header:     "gzip",
+			body:       []byte("12345678903"),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown encoding",
+			encoding:   "",
+			header:     "br",
+			body:       []byte("12345678903"),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := Decompress(1 << 20)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got = string(b)
+				if r.Header.Get("Content-Encoding") != "" {
+					t.Error("Content-Encoding is left")
+				}
+				if r.ContentLength != int64(len(b)) {
+					t.Errorf("ContentLength = %d, want %d", r.ContentLength, len(b))
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewReader(encode(t, tt.encoding, tt.body)))
+			if tt.header != "" {
+				r.Header.Set("Content-Encoding", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "success test #1", accept: "gzip, deflate, br", want: "gzip"},
+		{name: "success test #2", accept: "deflate", want: "deflate"},
+		{name: "success test #3", accept: "gzip;q=0.5, deflate;q=0.8", want: "deflate"},
+		{name: "success test #4", accept: "*", want: "gzip"},
+		{name: "success test #5", accept: "gzip;q=0, *", want: "deflate"},
+		{name: "success test #6", accept: "", want: ""},
+		{name: "success test #7", accept: "br, identity", want: ""},
+		{name: "success test #8", accept: "GZIP;q=1.0", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.accept); got != tt.want {
+				t.Errorf("Negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat(`{"number":"12345678903","status":"PROCESSED"},`, 100)
+	tests := []struct {
+		name         string
+		accept       string
+		contentType  string
+		body         string
+		status       int
+		wantEncoding string
+	}{
+		{
+			name:         "large gzip",
+			accept:       "gzip",
+			contentType:  "application/json",
+			body:         long,
+			status:       http.StatusOK,
+			wantEncoding: "gzip",
+		},
+		{
+			name:         "large deflate",
+			accept:       "deflate",
+			contentType:  "application/json",
+			body:         long,
+			status:       http.StatusOK,
+			wantEncoding: "deflate",
+		},
+		{
+			name:         "below threshold",
+			accept:       "gzip",
+			contentType:  "application/json",
+			body:         `{"current":500.5,"withdrawn":42}`,
+			status:       http.StatusOK,
+			wantEncoding: "",
+		},
+		{
+			name:         "not accepted",
+			accept:       "",
+			contentType:  "application/json",
+			body:         long,
+			status:       http.StatusOK,
+			wantEncoding: "",
+		},
+		{
+			name:         "event stream",
+			accept:       "gzip",
+			contentType:  "text/event-stream",
+			body:         long,
+			status:       http.StatusOK,
+			wantEncoding: "",
+		},
+		{
+			name:         "no content",
+			accept:       "gzip",
+			status:       http.StatusNoContent,
+			wantEncoding: "",
+		},
+		{
+			name:         "error status keeps the code",
+			accept:       "gzip",
+			contentType:  "application/json",
+			body:         long,
+			status:       http.StatusConflict,
+			wantEncoding: "gzip",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				// пишем частями, чтобы порог набирался постепенно
+				for i := 0; i < len(tt.body); i += 100 {
+					end := i + 100
+					if end > len(tt.body) {
+						end = len(tt.body)
+					}
+					_, _ = w.Write([]byte(tt.body[i:end]))
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q", got)
+			}
+
+			var reader io.Reader = w.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gz
+			case "deflate":
+				zr, err := zlib.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = zr
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("body of %d bytes, want %d", len(got), len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/logger"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lib/pq"
+	"sync"
+	"time"
+)
+
+var log = logger.New()
+
+// subscriberBuffer is how many events a slow subscriber may lag behind before it is dropped
+const subscriberBuffer = 16
+
+// Broker fans out user events to the subscribers of this instance
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan repo.Event]struct{}
+}
+
+// NewBroker
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string]map[chan repo.Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel with events of the user and a function to unsubscribe,
+// the channel is closed when the subscriber can't keep up
+func (b *Broker) Subscribe(login string) (<-chan repo.Event, func()) {
+	ch := make(chan repo.Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[login] == nil {
+		b.subs[login] = make(map[chan repo.Event]struct{})
+	}
+	b.subs[login][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(login, ch)
+	}
+}
+
+// Publish
+func (b *Broker) Publish(event repo.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[event.Login] {
+		select {
+		case ch <- event:
+		default:
+			log.Info().Msgf("events subscriber of %s is too slow", event.Login)
+			b.remove(event.Login, ch)
+		}
+	}
+}
+
+// remove must be called with the lock held
+func (b *Broker) remove(login string, ch chan repo.Event) {
+	if _, ok := b.subs[login][ch]; !ok {
+		return
+	}
+	delete(b.subs[login], ch)
+	close(ch)
+	if len(b.subs[login]) == 0 {
+		delete(b.subs, login)
+	}
+}
+
+// Listen receives events from every instance through Postgres LISTEN/NOTIFY
+func (b *Broker) Listen(ctx context.Context, databaseURI string) error {
+	listener := pq.NewListener(databaseURI, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Err(err).Msg("events listener error")
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(repo.EventsChannel); err != nil {
+		return err
+	}
+
+	log.Info().Msg("events listener is up...")
+
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n := <-listener.Notify:
+			if n == nil {
+				continue
+			}
+			var event repo.Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				log.Err(err).Msg("events notification error")
+				continue
+			}
+			b.Publish(event)
+		case <-ticker.C:
+			if err := listener.Ping(); err != nil {
+				log.Err(err).Msg("events listener ping error")
+			}
+		}
+	}
+}
+
+var broker = NewBroker()
+
+// Subscribe to the events of the user
+func Subscribe(login string) (<-chan repo.Event, func()) {
+	return broker.Subscribe(login)
+}
+
+// Listen starts delivering notifications to the subscribers
+func Listen(ctx context.Context, databaseURI string) error {
+	return broker.Listen(ctx, databaseURI)
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/lekan/gophermart/internal/repo"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	tests := []struct {
+		name  string
+		login string
+		event repo.Event
+		want  bool
+	}{
+		{
+			name:  "success test #1",
+			login: "user",
+			event: repo.Event{ID: 1, Login: "user", Type: repo.EventOrder},
+			want:  true,
+		},
+		{
+			name:  "other user test #1",
+			login: "user",
+			event: repo.Event{ID: 2, Login: "other", Type: repo.EventBalance},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker()
+			ch, unsubscribe := b.Subscribe(tt.login)
+			defer unsubscribe()
+
+			b.Publish(tt.event)
+
+			select {
+			case got := <-ch:
+				if !tt.want || got.ID != tt.event.ID {
+					t.Errorf("Publish() delivered %+v, want delivered %v", got, tt.want)
+				}
+			default:
+				if tt.want {
+					t.Errorf("Publish() didn't deliver %+v", tt.event)
+				}
+			}
+		})
+	}
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	ch, unsubscribe := b.Subscribe("user")
+	defer unsubscribe()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(repo.Event{ID: int64(i), Login: "user"})
+	}
+
+	count := 0
+	for range ch {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events, want %d", count, subscriberBuffer)
+	}
+}
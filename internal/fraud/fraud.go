@@ -0,0 +1,178 @@
+// Package fraud holds the velocity rules for order uploads: too many attempts, rejected
+// or invalid numbers from one user or one address within a window throttle or flag the user
+package fraud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Subjects
+const (
+	SubjectUser = "user"
+	SubjectIP   = "ip"
+)
+
+// Actions
+const (
+	ActionThrottle = "throttle"
+	ActionFlag     = "flag"
+)
+
+// Rule, zero limits are not checked
+type Rule struct {
+	Name        string        `json:"name"`
+	Subject     string        `json:"subject"`
+	Window      time.Duration `json:"-"`
+	MaxAttempts int           `json:"max_attempts,omitempty"`
+	MaxRejected int           `json:"max_rejected,omitempty"`
+	MaxInvalid  int           `json:"max_invalid,omitempty"`
+	Action      string        `json:"action"`
+}
+
+// ruleJSON has the window as "1m"
+type ruleJSON struct {
+	ruleAlias
+	Window string `json:"window"`
+}
+
+type ruleAlias Rule
+
+// MarshalJSON
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ruleJSON{ruleAlias: ruleAlias(r), Window: r.Window.String()})
+}
+
+// UnmarshalJSON
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	var v ruleJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(v.Window)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", v.Name, err)
+	}
+	*r = Rule(v.ruleAlias)
+	r.Window = window
+	return nil
+}
+
+// Validate
+func (r Rule) Validate() error {
+	switch {
+	case r.Name == "":
+		return errors.New("rule without name")
+	case r.Subject != SubjectUser && r.Subject != SubjectIP:
+		return fmt.Errorf("rule %s: unknown subject %q", r.Name, r.Subject)
+	case r.Action != ActionThrottle && r.Action != ActionFlag:
+		return fmt.Errorf("rule %s: unknown action %q", r.Name, r.Action)
+	case r.Window <= 0:
+		return fmt.Errorf("rule %s: window must be positive", r.Name)
+	case r.MaxAttempts <= 0 && r.MaxRejected <= 0 && r.MaxInvalid <= 0:
+		return fmt.Errorf("rule %s: no limits", r.Name)
+	}
+	return nil
+}
+
+// Stats of a subject within the window of a rule. Rejected are uploads answered
+// with 409 or 422, Invalid are orders the accrual system marked INVALID.
+type Stats struct {
+	Attempts int `db:"attempts"`
+	Rejected int `db:"rejected"`
+	Invalid  int `db:"invalid"`
+}
+
+// Match returns why the stats break the rule
+func (r Rule) Match(s Stats) (string, bool) {
+	switch {
+	case r.MaxAttempts > 0 && s.Attempts > r.MaxAttempts:
+		return fmt.Sprintf("%d uploads in %s", s.Attempts, r.Window), true
+	case r.MaxRejected > 0 && s.Rejected > r.MaxRejected:
+		return fmt.Sprintf("%d rejected uploads in %s", s.Rejected, r.Window), true
+	case r.MaxInvalid > 0 && s.Invalid > r.MaxInvalid:
+		return fmt.Sprintf("%d invalid orders in %s", s.Invalid, r.Window), true
+	}
+	return "", false
+}
+
+// Hit is a broken rule
+type Hit struct {
+	Rule   string
+	Reason string
+}
+
+// Decision
+type Decision struct {
+	Throttle   bool
+	RetryAfter time.Duration
+	Flags      []Hit
+}
+
+// Evaluate checks every rule against the stats of its subject and window
+func Evaluate(rules []Rule, stats func(Rule) (Stats, error)) (Decision, error) {
+	var d Decision
+	for _, r := range rules {
+		s, err := stats(r)
+		if err != nil {
+			return Decision{}, err
+		}
+		reason, ok := r.Match(s)
+		if !ok {
+			continue
+		}
+
+		switch r.Action {
+		case ActionThrottle:
+			d.Throttle = true
+			if r.Window > d.RetryAfter {
+				d.RetryAfter = r.Window
+			}
+		case ActionFlag:
+			d.Flags = append(d.Flags, Hit{Rule: r.Name, Reason: reason})
+		}
+	}
+	return d, nil
+}
+
+// Operations that spend points
+const (
+	OpWithdraw = "withdraw"
+	OpHold     = "hold"
+	OpCapture  = "capture"
+	OpTransfer = "transfer"
+	OpRedeem   = "redeem"
+)
+
+// What happens to an operation of a flagged user
+const (
+	Allow  = "allow"
+	Review = "review"
+	Deny   = "deny"
+)
+
+// Restrict decides on an operation of the user. Withdrawals for an order, captured holds included,
+// wait for an admin in the review queue, the points can not leave the account any other way.
+func Restrict(op string, flagged bool) string {
+	if !flagged {
+		return Allow
+	}
+	switch op {
+	case OpWithdraw, OpCapture:
+		return Review
+	}
+	return Deny
+}
+
+// DefaultRules
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "user_burst", Subject: SubjectUser, Window: time.Minute, MaxAttempts: 30, Action: ActionThrottle},
+		{Name: "ip_burst", Subject: SubjectIP, Window: time.Minute, MaxAttempts: 60, Action: ActionThrottle},
+		{Name: "ip_rejected", Subject: SubjectIP, Window: time.Hour, MaxRejected: 50, Action: ActionThrottle},
+		{Name: "user_rejected", Subject: SubjectUser, Window: time.Hour, MaxRejected: 20, Action: ActionFlag},
+		{Name: "user_invalid", Subject: SubjectUser, Window: 24 * time.Hour, MaxInvalid: 10, Action: ActionFlag},
+	}
+}
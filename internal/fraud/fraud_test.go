@@ -0,0 +1,145 @@
+package fraud
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := DefaultRules()
+
+	tests := []struct {
+		name       string
+		user       Stats
+		ip         Stats
+		throttle   bool
+		retryAfter time.Duration
+		flags      []string
+	}{
+		{
+			name: "success test #1",
+			user: Stats{Attempts: 10, Rejected: 2},
+			ip:   Stats{Attempts: 10, Rejected: 2},
+		},
+		{
+			name:       "user burst",
+			user:       Stats{Attempts: 31},
+			ip:         Stats{Attempts: 31},
+			throttle:   true,
+			retryAfter: time.Minute,
+		},
+		{
+			name:       "address with many rejections",
+			user:       Stats{Attempts: 5},
+			ip:         Stats{Attempts: 51, Rejected: 51},
+			throttle:   true,
+			retryAfter: time.Hour,
+		},
+		{
+			name:       "user guessing numbers",
+			user:       Stats{Attempts: 25, Rejected: 21, Invalid: 11},
+			ip:         Stats{Attempts: 25, Rejected: 21},
+			throttle:   false,
+			retryAfter: 0,
+			flags:      []string{"user_rejected", "user_invalid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Evaluate(rules, func(r Rule) (Stats, error) {
+				if r.Subject == SubjectIP {
+					return tt.ip, nil
+				}
+				return tt.user, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Throttle != tt.throttle || d.RetryAfter != tt.retryAfter {
+				t.Errorf("Evaluate() throttle = %v %v, want %v %v", d.Throttle, d.RetryAfter, tt.throttle, tt.retryAfter)
+			}
+			if len(d.Flags) != len(tt.flags) {
+				t.Fatalf("Evaluate() flags = %+v, want %v", d.Flags, tt.flags)
+			}
+			for i := range d.Flags {
+				if d.Flags[i].Rule != tt.flags[i] {
+					t.Errorf("flag %d = %s, want %s", i, d.Flags[i].Rule, tt.flags[i])
+				}
+			}
+		})
+	}
+
+	wantErr := errors.New("db is down")
+	if _, err := Evaluate(rules, func(Rule) (Stats, error) { return Stats{}, wantErr }); !errors.Is(err, wantErr) {
+		t.Errorf("Evaluate() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestRuleJSON(t *testing.T) {
+	b, err := json.Marshal(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(b, &rules); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range DefaultRules() {
+		if rules[i] != r {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], r)
+		}
+		if err = rules[i].Validate(); err != nil {
+			t.Errorf("rule %d: %v", i, err)
+		}
+	}
+
+	bad := []string{
+		`{"name": "x", "subject": "user", "window": "soon", "max_attempts": 1, "action": "flag"}`,
+	}
+	for _, v := range bad {
+		var r Rule
+		if err = json.Unmarshal([]byte(v), &r); err == nil {
+			t.Errorf("Unmarshal(%s) must fail", v)
+		}
+	}
+
+	invalid := []Rule{
+		{Name: "x", Subject: "device", Window: time.Minute, MaxAttempts: 1, Action: ActionFlag},
+		{Name: "x", Subject: SubjectUser, Window: time.Minute, MaxAttempts: 1, Action: "ban"},
+		{Name: "x", Subject: SubjectUser, Window: time.Minute, Action: ActionFlag},
+		{Name: "x", Subject: SubjectUser, MaxAttempts: 1, Action: ActionFlag},
+	}
+	for _, r := range invalid {
+		if err = r.Validate(); err == nil {
+			t.Errorf("Validate(%+v) must fail", r)
+		}
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      string
+		flagged bool
+		want    string
+	}{
+		{name: "withdraw", op: OpWithdraw, want: Allow},
+		{name: "transfer", op: OpTransfer, want: Allow},
+		{name: "flagged withdraw", op: OpWithdraw, flagged: true, want: Review},
+		{name: "flagged capture", op: OpCapture, flagged: true, want: Review},
+		{name: "flagged hold", op: OpHold, flagged: true, want: Deny},
+		{name: "flagged transfer", op: OpTransfer, flagged: true, want: Deny},
+		{name: "flagged redeem", op: OpRedeem, flagged: true, want: Deny},
+		{name: "flagged unknown", op: "gift", flagged: true, want: Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Restrict(tt.op, tt.flagged); got != tt.want {
+				t.Errorf("Restrict(%s, %v) = %s, want %s", tt.op, tt.flagged, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/signature"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderAccrualTimestamp = "X-Accrual-Timestamp"
+	HeaderAccrualSignature = "X-Accrual-Signature"
+
+	callbackMaxSkew = 5 * time.Minute
+	callbackMaxBody = 1 << 16
+)
+
+// AccrualCallback takes the same JSON as GET /api/orders/{number} of the accrual system.
+// The tenant comes from the order: the one whose secret signed the callback.
+func AccrualCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, callbackMaxBody))
+	defer r.Body.Close()
+	if err != nil {
+		log.Err(err).Msg("read callback error")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get(HeaderAccrualTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		log.Err(err).Msg("wrong callback timestamp")
+		problem.Status(w, http.StatusUnauthorized)
+		return
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > callbackMaxSkew || skew < -callbackMaxSkew {
+		log.Info().Msg("callback timestamp is out of range")
+		problem.Status(w, http.StatusUnauthorized)
+		return
+	}
+
+	order := repo.Order{}
+	if err = json.Unmarshal(body, &order); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+
+	tenants, err := repo.OrderTenants(ctx, order.OrderID)
+	if err != nil {
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+	if len(tenants) == 0 {
+		log.Info().Msgf("callback for unknown order %s", order.OrderID)
+		problem.Status(w, http.StatusNotFound)
+		return
+	}
+
+	var tenant *repo.Tenant
+	enabled := false
+	for i, t := range tenants {
+		secret := t.CallbackSecret()
+		if secret == "" {
+			continue
+		}
+		enabled = true
+		if signature.Verify(secret, timestamp, body, r.Header.Get(HeaderAccrualSignature)) {
+			tenant = &tenants[i]
+			break
+		}
+	}
+	if !enabled {
+		log.Info().Msg("accrual callback is disabled")
+		problem.Status(w, http.StatusNotFound)
+		return
+	}
+	if tenant == nil {
+		log.Info().Msg("wrong callback signature")
+		problem.Status(w, http.StatusUnauthorized)
+		return
+	}
+	ctx = repo.WithTenant(ctx, *tenant)
+
+	switch order.Status {
+	case "REGISTERED", "PROCESSING", "INVALID", "PROCESSED":
+	default:
+		log.Info().Msgf("unknown accrual status %s", order.Status)
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+	if order.OrderID == "" || order.Accrual < 0 {
+		log.Info().Msg("wrong accrual callback")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	applied, err := repo.ApplyAccrual(ctx, order)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			log.Info().Msgf("callback for unknown order %s", order.OrderID)
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("apply accrual error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Msgf("accrual callback for %s applied: %v", order.OrderID, applied)
+	w.WriteHeader(http.StatusOK)
+}
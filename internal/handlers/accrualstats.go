@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+)
+
+func GetAccrualStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := repo.GetAccrualStats(r.Context())
+	if !ok {
+		log.Info().Msg("accrual client has no stats")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&stats); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
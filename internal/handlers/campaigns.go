@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/campaigns"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+func GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetCampaigns(ctx)
+	if err != nil {
+		log.Err(err).Msg("get campaigns error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	res, err := repo.GetCampaign(ctx, id)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// CreateCampaign, with ?dry_run=true only previews the campaign
+func CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	c := campaigns.Campaign{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		previewCampaign(w, r, c)
+		return
+	}
+
+	res, err := repo.CreateCampaign(ctx, c)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// UpdateCampaign, with ?dry_run=true only previews the new rules
+func UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	c, err := repo.GetCampaign(ctx, id)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	if err = json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		previewCampaign(w, r, c)
+		return
+	}
+
+	res, err := repo.UpdateCampaign(ctx, id, c)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func StopCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	if err = repo.StopCampaign(ctx, id); err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func previewCampaign(w http.ResponseWriter, r *http.Request, c campaigns.Campaign) {
+	res, err := repo.PreviewCampaign(r.Context(), c)
+	if err != nil {
+		writeCampaignError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func writeCampaignError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		problem.Status(w, http.StatusNotFound)
+	case errors.Is(err, repo.ErrBadCampaign):
+		log.Info().Err(err).Msg("wrong campaign")
+		problem.Write(w, http.StatusBadRequest, problem.CodeValidation, err.Error())
+	default:
+		log.Err(err).Msg("campaign error")
+		problem.Status(w, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+func GetCatalog(w http.ResponseWriter, r *http.Request) {
+	writeCatalog(w, r, true)
+}
+
+// GetAdminCatalog lists inactive and sold out items too
+func GetAdminCatalog(w http.ResponseWriter, r *http.Request) {
+	writeCatalog(w, r, false)
+}
+
+func writeCatalog(w http.ResponseWriter, r *http.Request, onlyAvailable bool) {
+	res, err := repo.GetCatalog(r.Context(), onlyAvailable)
+	if err != nil {
+		log.Err(err).Msg("get catalog error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// redeemCodes, 403 and 409 of the withdrawal come as policy violations
+var redeemCodes = map[int]string{
+	http.StatusForbidden: problem.CodeAccountUnderReview,
+	http.StatusNotFound:  problem.CodeItemNotFound,
+	http.StatusGone:      problem.CodeItemUnavailable,
+	http.StatusConflict:  problem.CodeOutOfStock,
+}
+
+func RedeemItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	req := repo.RedeemRequest{}
+	if r.ContentLength != 0 {
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Err(err).Msg("json decode error")
+			problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	res, statusCode, err := repo.RedeemItem(ctx, login, id, req)
+	if statusCode != http.StatusOK {
+		writeStatus(w, statusCode, err, redeemCodes)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetRedemptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := repo.GetRedemptions(ctx, login)
+	if err != nil {
+		log.Err(err).Msg("get redemptions error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	if len(res) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func CreateItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	item := repo.CatalogItem{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, err := repo.CreateItem(ctx, item)
+	if err != nil {
+		writeItemError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func UpdateItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	item, err := repo.GetItem(ctx, id)
+	if err != nil {
+		writeItemError(w, err)
+		return
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, err := repo.UpdateItem(ctx, id, item)
+	if err != nil {
+		writeItemError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func DeactivateItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	if err = repo.DeactivateItem(r.Context(), id); err != nil {
+		writeItemError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeItemError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		problem.Status(w, http.StatusNotFound)
+	case errors.Is(err, repo.ErrBadItem):
+		log.Info().Err(err).Msg("wrong catalog item")
+		problem.Write(w, http.StatusBadRequest, problem.CodeValidation, err.Error())
+	default:
+		log.Err(err).Msg("catalog item error")
+		problem.Status(w, http.StatusInternalServerError)
+	}
+}
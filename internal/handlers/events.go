@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/lekan/gophermart/internal/events"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/sessions"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+func Events(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session, err := sessions.Get(r)
+	if err != nil {
+		log.Err(err).Msg("Session error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+	value := session.Values["login"]
+	login, ok := value.(string)
+	if !ok {
+		log.Info().Msg("type assertion error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Info().Msg("streaming unsupported")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	var lastID int64
+	if lastEventID != "" {
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			log.Err(err).Msg("wrong Last-Event-ID")
+			problem.Status(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// подписываемся до чтения истории, чтобы не потерять события между ними
+	ch, unsubscribe := events.Subscribe(login)
+	defer unsubscribe()
+
+	// история нужна только переподключившемуся клиенту, новый поток начинается с текущего события
+	missed := []repo.Event{}
+	if lastEventID != "" {
+		missed, err = repo.GetEventsSince(ctx, login, lastID)
+	} else {
+		lastID, err = repo.GetLastEventID(ctx, login)
+	}
+	if err != nil {
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		if err = writeEvent(w, event); err != nil {
+			return
+		}
+		lastID = event.ID
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			if err = writeEvent(w, event); err != nil {
+				return
+			}
+			lastID = event.ID
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent
+func writeEvent(w http.ResponseWriter, event repo.Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Payload)
+	if err != nil {
+		log.Err(err).Msg("write event error")
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+// clearRequest
+type clearRequest struct {
+	Note string `json:"note"`
+}
+
+func GetFraudFlags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = repo.FlagOpen
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	res, err := repo.GetFraudFlags(ctx, status)
+	if err != nil {
+		log.Err(err).Msg("get fraud flags error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func ClearFraudFlag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	req := clearRequest{}
+	if r.ContentLength != 0 {
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Err(err).Msg("json decode error")
+			problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	res, err := repo.ClearFraudFlag(ctx, id, req.Note)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("clear fraud flag error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetReviewHolds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetReviewHolds(ctx)
+	if err != nil {
+		log.Err(err).Msg("get review holds error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func ApproveReview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	hold, statusCode, err := repo.ApproveReview(r.Context(), id)
+	writeHold(w, hold, statusCode, err)
+}
+
+func RejectReview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	hold, statusCode, err := repo.RejectReview(r.Context(), id)
+	writeHold(w, hold, statusCode, err)
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
@@ -13,28 +15,38 @@ func GetBalance(w http.ResponseWriter, r *http.Request) {
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 	value := session.Values["login"]
 	login, ok := value.(string)
 	if !ok {
 		log.Info().Msg("type assertion error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	balance, err := repo.GetBalance(ctx, login)
 	if err != nil {
 		log.Err(err).Msg("get balance error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
+	// разбивка по сгорающим баллам только по запросу, поля спецификации не меняются
+	if r.URL.Query().Get("breakdown") == "expiring" {
+		balance.ExpiringSoon, err = repo.GetExpiringPoints(ctx, login, config.Get().PointsExpiringSoon)
+		if err != nil {
+			log.Err(err).Msg("get expiring points error")
+			problem.Status(w, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(&balance); err != nil {
 		log.Err(err).Msg("json encoding error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
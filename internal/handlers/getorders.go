@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
@@ -13,7 +14,7 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 	value := session.Values["login"]
@@ -21,14 +22,13 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 	login, ok := value.(string)
 	if !ok {
 		log.Info().Msg("type assertion error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	if login == "" {
 		log.Info().Msg("get orders unauthorized")
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		problem.Status(w, http.StatusUnauthorized)
 		return
 	}
 
@@ -41,16 +41,14 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Err(err).Msg("get orders database error")
-		w.Header().Add("Content-Type", "application/json")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Add("Content-Type", "application/json")
 	if err = json.NewEncoder(w).Encode(&res); err != nil {
 		log.Err(err).Msg("json encoding error")
-		w.Header().Add("Content-Type", "application/json")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
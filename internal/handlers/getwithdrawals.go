@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
@@ -13,7 +14,7 @@ func GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 	value := session.Values["login"]
@@ -21,14 +22,14 @@ func GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	login, ok := value.(string)
 	if !ok {
 		log.Info().Msg("type assertion error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	res, err := repo.GetWithdrawals(ctx, login)
 	if err != nil {
 		log.Err(err).Msg("database error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
@@ -38,12 +39,12 @@ func GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Add("Content-Type", "application/json")
 	if err = json.NewEncoder(w).Encode(&res); err != nil {
 		log.Err(err).Msg("json encoder error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
@@ -2,6 +2,27 @@ package handlers
 
 import (
 	"github.com/lekan/gophermart/internal/logger"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/sessions"
+	"net/http"
 )
 
 var log = logger.New()
+
+// sessionLogin returns the login of the authenticated user, on failure the response is already written
+func sessionLogin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	session, err := sessions.Get(r)
+	if err != nil {
+		log.Err(err).Msg("Session error")
+		problem.Status(w, http.StatusInternalServerError)
+		return "", false
+	}
+	value := session.Values["login"]
+	login, ok := value.(string)
+	if !ok {
+		log.Info().Msg("type assertion error")
+		problem.Status(w, http.StatusInternalServerError)
+		return "", false
+	}
+	return login, true
+}
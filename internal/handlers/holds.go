@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+func CreateHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	req := &repo.HoldRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	hold, statusCode, err := repo.CreateHold(ctx, login, req)
+	writeHold(w, hold, statusCode, err)
+}
+
+func GetHolds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := repo.GetHolds(ctx, login)
+	if err != nil {
+		log.Err(err).Msg("get holds error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	if len(res) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func CaptureHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Err(err).Msg("wrong hold id")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	req := &repo.CaptureRequest{}
+	if r.ContentLength != 0 {
+		if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+			log.Err(err).Msg("json decode error")
+			problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	hold, statusCode, err := repo.CaptureHold(ctx, login, id, req)
+	writeHold(w, hold, statusCode, err)
+}
+
+func VoidHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Err(err).Msg("wrong hold id")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	hold, statusCode, err := repo.VoidHold(ctx, login, id)
+	writeHold(w, hold, statusCode, err)
+}
+
+// holdCodes
+var holdCodes = map[int]string{
+	http.StatusNotFound: problem.CodeHoldNotFound,
+	http.StatusConflict: problem.CodeHoldClosed,
+}
+
+// writeHold
+func writeHold(w http.ResponseWriter, hold repo.Hold, statusCode int, err error) {
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated && statusCode != http.StatusAccepted {
+		writeStatus(w, statusCode, err, holdCodes)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err = json.NewEncoder(w).Encode(&hold); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"io"
@@ -13,21 +14,20 @@ func Orders(w http.ResponseWriter, r *http.Request) {
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 	value := session.Values["login"]
 	login, ok := value.(string)
 	if !ok {
 		log.Info().Msg("type assertion error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	if login == "" {
 		log.Info().Msg("post order unauthorized")
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
+		problem.Status(w, http.StatusUnauthorized)
 		return
 	}
 
@@ -35,24 +35,25 @@ func Orders(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	if err != nil {
 		log.Err(err).Msg("take orderID error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	statusCode, err := repo.PostOrder(ctx, login, orderID)
-	if err != nil {
-		if statusCode == http.StatusNoContent {
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-
-		log.Err(err).Int("PostOrder error, status code: %d", statusCode)
-		w.Header().Add("Content-Type", "application/json")
-		http.Error(w, err.Error(), statusCode)
+	if statusCode >= http.StatusBadRequest {
+		writeStatus(w, statusCode, err, orderCodes)
 		return
 	}
+	if err != nil {
+		log.Err(err).Msgf("PostOrder error, status code: %d", statusCode)
+	}
 
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 }
+
+// orderCodes
+var orderCodes = map[int]string{
+	http.StatusConflict:            problem.CodeOrderConflict,
+	http.StatusUnprocessableEntity: problem.CodeInvalidOrderNumber,
+}
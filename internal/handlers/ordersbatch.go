@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/sessions"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const maxBatchSize = 100
+
+func OrdersBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session, err := sessions.Get(r)
+	if err != nil {
+		log.Err(err).Msg("Session error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+	value := session.Values["login"]
+	login, ok := value.(string)
+	if !ok {
+		log.Info().Msg("type assertion error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		log.Err(err).Msg("read batch error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	orderIDs, err := parseOrderNumbers(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		log.Err(err).Msg("batch format error")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	res, err := repo.PostOrders(ctx, login, orderIDs)
+	if err != nil {
+		log.Err(err).Msg("post orders error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	statusCode := http.StatusOK
+	for _, v := range res {
+		if v.Status == http.StatusAccepted {
+			statusCode = http.StatusAccepted
+			break
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// parseOrderNumbers accepts a JSON array of numbers or a newline separated list
+func parseOrderNumbers(contentType string, body []byte) ([]string, error) {
+	var orderIDs []string
+
+	if strings.HasPrefix(contentType, "application/json") {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, err
+		}
+		for _, v := range raw {
+			// не строку отдаем как есть, она вернется как неверный номер заказа
+			var orderID string
+			if err := json.Unmarshal(v, &orderID); err != nil {
+				orderID = string(bytes.TrimSpace(v))
+			}
+			orderIDs = append(orderIDs, strings.TrimSpace(orderID))
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			orderIDs = append(orderIDs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(orderIDs) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	if len(orderIDs) > maxBatchSize {
+		return nil, errors.New("batch is too large")
+	}
+
+	return orderIDs, nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/policy"
+	"github.com/lekan/gophermart/internal/problem"
+	"net/http"
+)
+
+func GetPolicy(w http.ResponseWriter, r *http.Request) {
+	p := policy.Get()
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&p); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func ReloadPolicy(w http.ResponseWriter, r *http.Request) {
+	p, err := policy.Reload()
+	if err != nil {
+		log.Err(err).Msg("policy reload error")
+		problem.Write(w, http.StatusUnprocessableEntity, problem.CodeValidation, err.Error())
+		return
+	}
+
+	log.Info().Msg("policy reloaded")
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&p); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
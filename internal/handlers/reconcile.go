@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func StartReconciliation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	opts := repo.ReconcileOptions{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			log.Err(err).Msg("json decode error")
+			problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	if opts.To.IsZero() {
+		opts.To = time.Now()
+	}
+	if opts.From.IsZero() {
+		opts.From = opts.To.Add(-config.Get().ReconcileWindow)
+	}
+	if !opts.From.Before(opts.To) || opts.Sample < 0 {
+		log.Info().Msg("wrong reconciliation window")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	rec, err := repo.LaunchReconciliation(ctx, opts)
+	if err != nil {
+		if errors.Is(err, repo.ErrReconcileRunning) {
+			problem.Write(w, http.StatusConflict, problem.CodeConflict, "the tenant is being reconciled")
+			return
+		}
+		log.Err(err).Msg("start reconciliation error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err = json.NewEncoder(w).Encode(&rec); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetReconciliations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetReconciliations(ctx)
+	if err != nil {
+		log.Err(err).Msg("get reconciliations error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// reconciliationReport
+type reconciliationReport struct {
+	repo.Reconciliation
+	Items []repo.ReconciliationItem `json:"items"`
+}
+
+func GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	rec, items, ok := loadReconciliation(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reconciliationReport{Reconciliation: rec, Items: items}); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// GetReconciliationReport sends the differences as a CSV file
+func GetReconciliationReport(w http.ResponseWriter, r *http.Request) {
+	rec, items, ok := loadReconciliation(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="reconciliation-%d.csv"`, rec.ID))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"order", "login", "stored_accrual", "remote_status", "remote_accrual", "difference", "error"})
+	for _, v := range items {
+		_ = cw.Write([]string{
+			v.OrderID,
+			v.Login,
+			strconv.FormatFloat(float64(v.StoredAccrual), 'f', 2, 32),
+			v.RemoteStatus,
+			strconv.FormatFloat(float64(v.RemoteAccrual), 'f', 2, 32),
+			strconv.FormatFloat(float64(v.Difference), 'f', 2, 32),
+			v.Error,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Err(err).Msg("csv writing error")
+	}
+}
+
+// loadReconciliation writes the error response itself
+func loadReconciliation(w http.ResponseWriter, r *http.Request) (repo.Reconciliation, []repo.ReconciliationItem, bool) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Err(err).Msg("wrong reconciliation id")
+		problem.Status(w, http.StatusBadRequest)
+		return repo.Reconciliation{}, nil, false
+	}
+
+	rec, err := repo.GetReconciliation(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return repo.Reconciliation{}, nil, false
+		}
+		log.Err(err).Msg("get reconciliation error")
+		problem.Status(w, http.StatusInternalServerError)
+		return repo.Reconciliation{}, nil, false
+	}
+
+	items, err := repo.GetReconciliationItems(ctx, id)
+	if err != nil {
+		log.Err(err).Msg("get reconciliation items error")
+		problem.Status(w, http.StatusInternalServerError)
+		return repo.Reconciliation{}, nil, false
+	}
+
+	return rec, items, true
+}
+
+func GetAdjustments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetAdjustments(ctx, r.URL.Query().Get("status"))
+	if err != nil {
+		log.Err(err).Msg("get adjustments error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func ApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	decideAdjustment(w, r, true)
+}
+
+func RejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	decideAdjustment(w, r, false)
+}
+
+func decideAdjustment(w http.ResponseWriter, r *http.Request, approve bool) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Err(err).Msg("wrong adjustment id")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	adj, err := repo.DecideAdjustment(ctx, id, approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, repo.ErrNotFound):
+			problem.Status(w, http.StatusNotFound)
+		case errors.Is(err, repo.ErrInsufficientFunds):
+			log.Info().Msgf("adjustment %d exceeds the balance", id)
+			problem.Status(w, http.StatusConflict)
+		default:
+			log.Err(err).Msg("decide adjustment error")
+			problem.Status(w, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&adj); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+)
+
+func GetReferrals(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := repo.GetReferrals(ctx, login)
+	if err != nil {
+		log.Err(err).Msg("get referrals error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+)
+
+func ReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := repo.ReverseRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Err(err).Msg("json decode error")
+			problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	rev, err := repo.ReverseWithdrawal(ctx, chi.URLParam(r, "order"), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, repo.ErrNotFound):
+			problem.Status(w, http.StatusNotFound)
+		case errors.Is(err, repo.ErrBadAmount):
+			problem.Status(w, http.StatusBadRequest)
+		case errors.Is(err, repo.ErrOverReversal):
+			log.Info().Msg("reversal exceeds the withdrawal")
+			problem.Status(w, http.StatusConflict)
+		case errors.Is(err, repo.ErrPartialRedemption):
+			problem.Write(w, http.StatusConflict, problem.CodeConflict, err.Error())
+		default:
+			log.Err(err).Msg("reverse withdrawal error")
+			problem.Status(w, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&rev); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetReversals(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetReversals(ctx, chi.URLParam(r, "order"))
+	if err != nil {
+		log.Err(err).Msg("get reversals error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
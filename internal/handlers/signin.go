@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
@@ -17,7 +18,7 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	//получаем body
 	if err := json.NewDecoder(r.Body).Decode(creds); err != nil {
 		log.Err(err).Msg("json error")
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
 		return
 	}
 	defer r.Body.Close()
@@ -27,18 +28,18 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			log.Info().Msg("user does not exist")
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Write(w, http.StatusUnauthorized, problem.CodeInvalidCredentials, "")
 			return
 		}
 		log.Err(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
@@ -47,10 +48,10 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	err = session.Save(r, w)
 	if err != nil {
 		log.Err(err).Msg("save session error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Add("Context-Type", "application/json")
-	w.WriteHeader(200)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 }
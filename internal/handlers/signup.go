@@ -3,7 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
@@ -17,7 +17,7 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 	// получаем Body
 	if err := json.NewDecoder(r.Body).Decode(creds); err != nil {
 		log.Err(err).Msg("JSON error")
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
 		return
 	}
 	defer r.Body.Close()
@@ -25,20 +25,26 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 	// сохраняем в базу данных
 	err := repo.Signup(ctx, creds)
 	if err != nil {
-		if errors.Is(err, fmt.Errorf("409 %w", err)) {
+		if errors.Is(err, repo.ErrBadReferralCode) {
+			log.Info().Msgf("wrong referral code %s", creds.ReferralCode)
+			problem.Write(w, http.StatusBadRequest, problem.CodeValidation, "unknown referral code")
+			return
+		}
+		if errors.Is(err, repo.ErrLoginTaken) {
 			log.Info().Msg("Login is in use another user")
-			w.WriteHeader(http.StatusConflict)
+			problem.Write(w, http.StatusConflict, problem.CodeLoginTaken, "")
 			return
 		}
 		log.Err(err).Msg("Signup error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
+		return
 	}
 
 	// создаем сессию
 	session, err := sessions.Get(r)
 	if err != nil {
 		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
@@ -48,10 +54,10 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 	err = session.Save(r, w)
 	if err != nil {
 		log.Err(err).Msg("Save session error")
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Status(w, http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Add("Context-Type", "application/json")
-	w.WriteHeader(200)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 }
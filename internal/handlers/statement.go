@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	statementCSV   = "csv"
+	statementJSONL = "jsonl"
+)
+
+func GetStatement(w http.ResponseWriter, r *http.Request) {
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+	writeStatement(w, r, login)
+}
+
+// GetAdminStatement exports the statement of every user
+func GetAdminStatement(w http.ResponseWriter, r *http.Request) {
+	writeStatement(w, r, r.URL.Query().Get("login"))
+}
+
+// writeStatement streams the statement, the period defaults to the current month
+func writeStatement(w http.ResponseWriter, r *http.Request, login string) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, _, err = parseTime(v); err != nil {
+			log.Info().Err(err).Msg("wrong statement period")
+			problem.Status(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var dateOnly bool
+		if to, dateOnly, err = parseTime(v); err != nil {
+			log.Info().Err(err).Msg("wrong statement period")
+			problem.Status(w, http.StatusBadRequest)
+			return
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+	if !from.Before(to) {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = statementCSV
+	}
+
+	var (
+		write func(repo.StatementRow) error
+		flush func() error
+	)
+	switch format {
+	case statementCSV:
+		cw := csv.NewWriter(w)
+		write = func(v repo.StatementRow) error {
+			return cw.Write([]string{
+				v.Login,
+				v.Kind,
+				v.Order,
+				strconv.FormatFloat(float64(v.Amount), 'f', 2, 32),
+				strconv.FormatFloat(float64(v.Balance), 'f', 2, 32),
+				v.CreatedAt.Format(time.RFC3339),
+				v.Reference,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		w.Header().Add("Content-Type", "text/csv")
+		_ = cw.Write([]string{"login", "kind", "order", "amount", "balance", "created_at", "reference"})
+	case statementJSONL:
+		enc := json.NewEncoder(w)
+		write = func(v repo.StatementRow) error {
+			return enc.Encode(&v)
+		}
+		flush = func() error { return nil }
+		w.Header().Add("Content-Type", "application/x-ndjson")
+	default:
+		log.Info().Msgf("unknown statement format %s", format)
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s-%s.%s"`,
+		from.Format("20060102"), to.Format("20060102"), format))
+
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	err = repo.StreamStatement(ctx, login, from, to, func(v repo.StatementRow) error {
+		if err := write(v); err != nil {
+			return err
+		}
+		n++
+		if n%1000 == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && n == 0 {
+		// ничего ещё не отправлено, можно вернуть ошибку
+		log.Err(err).Msg("statement error")
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		log.Err(err).Msg("statement streaming error")
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+)
+
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	res, err := repo.GetTenants(r.Context())
+	if err != nil {
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func CreateTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	t := repo.Tenant{LuhnCheck: true, Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, err := repo.CreateTenant(ctx, t)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+
+	t, err := repo.GetTenant(ctx, id)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+	if err = json.NewDecoder(r.Body).Decode(&t); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, err := repo.UpdateTenant(ctx, id, t)
+	if err != nil {
+		writeTenantError(w, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func writeTenantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		problem.Status(w, http.StatusNotFound)
+	case errors.Is(err, repo.ErrBadTenant):
+		log.Info().Err(err).Msg("wrong tenant")
+		problem.Write(w, http.StatusBadRequest, problem.CodeValidation, err.Error())
+	default:
+		log.Err(err).Msg("tenant error")
+		problem.Status(w, http.StatusInternalServerError)
+	}
+}
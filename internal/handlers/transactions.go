@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+func GetTransactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := transactionFilter(r.URL.Query())
+	if err != nil {
+		log.Info().Err(err).Msg("wrong transactions query")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	res, err := repo.GetTransactions(ctx, login, f)
+	if err != nil {
+		log.Err(err).Msg("get transactions error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	if len(res) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// transactionFilter reads from, to, limit and offset, a date without time in to includes the whole day
+func transactionFilter(q url.Values) (repo.TransactionFilter, error) {
+	f := repo.TransactionFilter{Limit: defaultPageLimit}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, _, err = parseTime(v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		var dateOnly bool
+		if f.To, dateOnly, err = parseTime(v); err != nil {
+			return f, err
+		}
+		if dateOnly {
+			f.To = f.To.AddDate(0, 0, 1)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+		switch {
+		case f.Limit <= 0:
+			f.Limit = defaultPageLimit
+		case f.Limit > maxPageLimit:
+			f.Limit = maxPageLimit
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+		if f.Offset < 0 {
+			f.Offset = 0
+		}
+	}
+	return f, nil
+}
+
+// parseTime accepts RFC 3339 or a plain date
+func parseTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+)
+
+// transferCodes
+var transferCodes = map[int]string{
+	http.StatusForbidden: problem.CodeTransferLimit,
+	http.StatusNotFound:  problem.CodeRecipientNotFound,
+	http.StatusConflict:  problem.CodeIdempotencyKey,
+}
+
+func Transfer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	req := &repo.TransferRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	t, statusCode, err := repo.MakeTransfer(ctx, login, r.Header.Get("Idempotency-Key"), req)
+	if statusCode != http.StatusOK {
+		writeStatus(w, statusCode, err, transferCodes)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&t); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetTransfers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := repo.GetTransfers(ctx, login)
+	if err != nil {
+		log.Err(err).Msg("get transfers error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	if len(res) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
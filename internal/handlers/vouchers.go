@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+// voucherRequest
+type voucherRequest struct {
+	Code string `json:"code"`
+}
+
+// voucherCodes, an exhausted code is a conflict too and comes as its own violation
+var voucherCodes = map[int]string{
+	http.StatusNotFound: problem.CodeVoucherNotFound,
+	http.StatusGone:     problem.CodeVoucherExpired,
+	http.StatusConflict: problem.CodeVoucherRedeemed,
+}
+
+func RedeemVoucher(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, ok := sessionLogin(w, r)
+	if !ok {
+		return
+	}
+
+	req := voucherRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, statusCode, err := repo.RedeemVoucher(ctx, login, req.Code)
+	if statusCode != http.StatusOK {
+		writeStatus(w, statusCode, err, voucherCodes)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func CreateVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := repo.VoucherBatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	res, err := repo.CreateVoucherBatch(ctx, req)
+	if err != nil {
+		if errors.Is(err, repo.ErrBadVoucherBatch) {
+			log.Info().Err(err).Msg("wrong voucher batch")
+			problem.Write(w, http.StatusBadRequest, problem.CodeValidation, err.Error())
+			return
+		}
+		log.Err(err).Msg("create voucher batch error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetVoucherBatches(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetVoucherBatches(ctx)
+	if err != nil {
+		log.Err(err).Msg("get voucher batches error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func GetVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	res, err := repo.GetVoucherBatch(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("get voucher batch error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+// ExportVoucherBatch streams the codes of the batch as CSV
+func ExportVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	batch, err := repo.GetVoucherBatch(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("get voucher batch error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="vouchers-%d.csv"`, batch.ID))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"code", "value", "uses", "max_uses"})
+	value := strconv.FormatFloat(float64(batch.Value), 'f', 2, 32)
+	maxUses := strconv.Itoa(batch.MaxUses)
+	err = repo.StreamVouchers(ctx, batch.ID, func(v repo.Voucher) error {
+		return cw.Write([]string{v.Code, value, strconv.Itoa(v.Uses), maxUses})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		log.Err(err).Msg("voucher export error")
+	}
+}
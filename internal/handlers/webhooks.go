@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	res, err := repo.GetWebhooks(ctx)
+	if err != nil {
+		log.Err(err).Msg("get webhooks error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sub := &repo.WebhookSubscription{}
+	if err := json.NewDecoder(r.Body).Decode(sub); err != nil {
+		log.Err(err).Msg("json decode error")
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Info().Msgf("wrong webhook url %s", sub.URL)
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	if len(sub.EventTypes) == 0 {
+		log.Info().Msg("webhook without events")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+	for _, eventType := range sub.EventTypes {
+		if !contains(repo.WebhookEventTypes, eventType) {
+			log.Info().Msgf("unknown webhook event %s", eventType)
+			problem.Status(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if sub.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Err(err).Msg("webhook secret error")
+			problem.Status(w, http.StatusInternalServerError)
+			return
+		}
+		sub.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := repo.CreateWebhook(ctx, sub); err != nil {
+		log.Err(err).Msg("create webhook error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Err(err).Msg("wrong webhook id")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	if err = repo.DisableWebhook(ctx, id); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("delete webhook error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	subscriptionID := 0
+	if v := query.Get("subscription_id"); v != "" {
+		var err error
+		if subscriptionID, err = strconv.Atoi(v); err != nil {
+			log.Err(err).Msg("wrong subscription id")
+			problem.Status(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := repo.GetWebhookDeliveries(ctx, subscriptionID, query.Get("status"), 100)
+	if err != nil {
+		log.Err(err).Msg("get webhook deliveries error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&res); err != nil {
+		log.Err(err).Msg("json encoding error")
+	}
+}
+
+func ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		log.Err(err).Msg("wrong delivery id")
+		problem.Status(w, http.StatusBadRequest)
+		return
+	}
+
+	if err = repo.ReplayWebhookDelivery(ctx, id); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			problem.Status(w, http.StatusNotFound)
+			return
+		}
+		log.Err(err).Msg("replay webhook delivery error")
+		problem.Status(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// contains
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
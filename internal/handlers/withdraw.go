@@ -2,26 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/lekan/gophermart/internal/policy"
+	"github.com/lekan/gophermart/internal/problem"
 	"github.com/lekan/gophermart/internal/repo"
-	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
 )
 
 func Withdraw(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	session, err := sessions.Get(r)
-	if err != nil {
-		log.Err(err).Msg("Session error")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	value := session.Values["login"]
-	login, ok := value.(string)
+	login, ok := sessionLogin(w, r)
 	if !ok {
-		log.Info().Msg("type assertion error")
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -29,17 +21,32 @@ func Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Err(err).Msg("json decode error")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
 		return
 	}
 
 	statusCode, err := repo.Withdraw(ctx, login, req)
+	writeStatus(w, statusCode, err, nil)
+}
+
+// writeStatus answers with the status code from the repo. A broken withdrawal rule is sent with its code,
+// codes name the other statuses that mean something special for the handler; err is logged, never sent.
+func writeStatus(w http.ResponseWriter, statusCode int, err error, codes map[int]string) {
+	var v *policy.Violation
+	if errors.As(err, &v) {
+		problem.Write(w, statusCode, v.Code, v.Message)
+		return
+	}
 	if err != nil {
-		log.Err(err)
-		http.Error(w, err.Error(), statusCode)
+		log.Err(err).Msgf("status code: %d", statusCode)
+	}
+	if statusCode >= http.StatusInternalServerError {
+		problem.Internal(w, nil)
 		return
 	}
-
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
+	if code, ok := codes[statusCode]; ok {
+		problem.Write(w, statusCode, code, "")
+		return
+	}
+	problem.Status(w, statusCode)
 }
@@ -0,0 +1,55 @@
+package mware
+
+import (
+	"crypto/subtle"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"strings"
+)
+
+// CheckAdmin lets in the admin of the tenant of the request with the token of the tenant,
+// the default tenant falls back to the ADMIN_TOKEN
+func CheckAdmin(next http.Handler) http.Handler {
+	return checkToken(next, func(r *http.Request) string {
+		t := repo.TenantFrom(r.Context())
+		if t.AdminToken == "" && t.ID == repo.DefaultTenant {
+			return config.GetAdminToken()
+		}
+		return t.AdminToken
+	})
+}
+
+// CheckOperator lets in the operator of the deployment with the ADMIN_TOKEN, whatever the tenant
+func CheckOperator(next http.Handler) http.Handler {
+	return checkToken(next, func(*http.Request) string {
+		return config.GetAdminToken()
+	})
+}
+
+// checkToken compares the bearer token with the expected one, without a token the API is disabled
+func checkToken(next http.Handler, expected func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := expected(r)
+		if token == "" {
+			log.Info().Msg("admin API is disabled")
+			problem.Status(w, http.StatusForbidden)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			log.Info().Msg("admin access denied")
+			problem.Status(w, http.StatusUnauthorized)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			log.Info().Msg("wrong admin token")
+			problem.Status(w, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,15 +2,18 @@ package mware
 
 import (
 	"github.com/lekan/gophermart/internal/logger"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
 	"github.com/lekan/gophermart/internal/sessions"
 	"net/http"
+	"strings"
 )
 
 var log = logger.New()
 
 func CheckUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		notAuth := []string{"/api/user/register", "/api/user/login"}
+		notAuth := []string{"/api/user/register", "/api/user/login", "/openapi.json"}
 		requestPath := r.URL.Path
 		for _, value := range notAuth {
 			if value == requestPath {
@@ -19,28 +22,47 @@ func CheckUser(next http.Handler) http.Handler {
 			}
 		}
 
+		// админские и внутренние маршруты проверяются отдельно
+		if strings.HasPrefix(requestPath, "/api/admin/") || strings.HasPrefix(requestPath, "/internal/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		session, err := sessions.Get(r)
 		if err != nil {
 			log.Err(err).Msg("session initialization error")
 			//http.Error(w, err.Error(), 500)
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Status(w, http.StatusInternalServerError)
 			//next.ServeHTTP(w, r)
 			return
 		}
 		if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
 			log.Info().Msg("access denied")
 			//http.Error(w, err.Error(), http.StatusUnauthorized)
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Status(w, http.StatusUnauthorized)
 			//next.ServeHTTP(w, r)
 			return
 		}
-		if login, ok := session.Values["login"].(string); !ok || login == "" {
+		login, ok := session.Values["login"].(string)
+		if !ok || login == "" {
 			log.Info().Msg("unknown login")
 			//http.Error(w, err.Error(), http.StatusUnauthorized)
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Status(w, http.StatusUnauthorized)
 			//next.ServeHTTP(w, r)
 			return
 		}
+
+		// сессия другого тенанта здесь не действует
+		member, err := repo.BelongsToTenant(r.Context(), login)
+		if err != nil {
+			problem.Status(w, http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			log.Info().Msgf("%s is not a user of the tenant", login)
+			problem.Status(w, http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
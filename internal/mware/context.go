@@ -8,6 +8,15 @@ import (
 
 func SetContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		longLived := []string{"/api/user/events", "/api/user/statement", "/api/admin/statement"}
+		requestPath := r.URL.Path
+		for _, value := range longLived {
+			if value == requestPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
@@ -0,0 +1,93 @@
+package mware
+
+import (
+	"errors"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// TenantHeader names the tenant when the host doesn't, only trusted proxies may send it
+const TenantHeader = "X-Tenant-ID"
+
+// Tenant resolves the tenant of the request from the host or the header of a trusted proxy,
+// the repo calls made with the request context are scoped to it
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if trustedProxy(r.RemoteAddr) {
+			id = r.Header.Get(TenantHeader)
+		} else if r.Header.Get(TenantHeader) != "" {
+			log.Info().Msgf("tenant header from untrusted %s is ignored", r.RemoteAddr)
+		}
+
+		t, err := repo.ResolveTenant(r.Host, id)
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				log.Info().Msgf("unknown tenant, host: %s", r.Host)
+				problem.Write(w, http.StatusNotFound, problem.CodeUnknownTenant, "")
+				return
+			}
+			log.Err(err).Msg("tenant error")
+			problem.Status(w, http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(repo.WithTenant(r.Context(), t)))
+	})
+}
+
+// trustedProxies are parsed from the configuration once
+var trustedProxies struct {
+	once sync.Once
+	nets []*net.IPNet
+}
+
+// trustedProxy checks the peer against TRUSTED_PROXIES, a comma separated list of addresses and networks
+func trustedProxy(remoteAddr string) bool {
+	trustedProxies.once.Do(func() {
+		trustedProxies.nets = parseProxies(config.Get().TrustedProxies)
+	})
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxies, an address is a network of its own
+func parseProxies(list string) []*net.IPNet {
+	res := []*net.IPNet{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Warn().Msgf("wrong trusted proxy %s", v)
+			continue
+		}
+		res = append(res, n)
+	}
+	return res
+}
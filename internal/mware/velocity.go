@@ -0,0 +1,70 @@
+package mware
+
+import (
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/sessions"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Velocity applies the fraud rules to order uploads: throttled clients get 429,
+// the outcome of the upload is checked again to flag the user at once
+func Velocity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessions.Get(r)
+		if err != nil {
+			log.Err(err).Msg("session initialization error")
+			problem.Status(w, http.StatusInternalServerError)
+			return
+		}
+		login, _ := session.Values["login"].(string)
+
+		ip := clientIP(r)
+		ctx := repo.WithClientIP(r.Context(), ip)
+
+		// при ошибке базы не блокируем загрузку
+		d, err := repo.CheckVelocity(ctx, login, ip)
+		if err != nil {
+			log.Err(err).Msg("velocity check error")
+		} else if d.Throttle {
+			log.Info().Msgf("order uploads of %s from %s are throttled", login, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(d.RetryAfter.Seconds())))
+			problem.Status(w, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if _, err = repo.CheckVelocity(ctx, login, ip); err != nil {
+			log.Err(err).Msg("velocity check error")
+		}
+	})
+}
+
+// clientIP is the address of the peer. Behind a trusted proxy it is the nearest address in X-Forwarded-For
+// that is not a trusted proxy itself, the addresses further left can be forged by the client.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(r.RemoteAddr) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,364 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	schemaRef    = "#/components/schemas/"
+	parameterRef = "#/components/parameters/"
+	responseRef  = "#/components/responses/"
+)
+
+// document is the part of OpenAPI 3.0 the checks need, other fields are skipped
+type document struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas    map[string]*schema    `json:"schemas"`
+		Parameters map[string]*parameter `json:"parameters"`
+		Responses  map[string]*response  `json:"responses"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*parameter         `json:"parameters"`
+	RequestBody *requestBody         `json:"requestBody"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// schema, the keywords not listed here are not checked
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Pattern    string             `json:"pattern"`
+	Enum       []interface{}      `json:"enum"`
+	Minimum    *float64           `json:"minimum"`
+	MinLength  *int               `json:"minLength"`
+	MinItems   *int               `json:"minItems"`
+	MaxItems   *int               `json:"maxItems"`
+	Nullable   bool               `json:"nullable"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+
+	pattern *regexp.Regexp
+}
+
+// mustLoad
+func mustLoad(data []byte) *document {
+	d, err := load(data)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// load parses the document, replaces the references to the parameters and the responses
+// and checks the references to the schemas
+func load(data []byte) (*document, error) {
+	d := &document{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+
+	for name, s := range d.Components.Schemas {
+		if err := d.prepare(s); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	for path, item := range d.Paths {
+		for method, op := range item {
+			if err := d.prepareOperation(op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+	return d, nil
+}
+
+// prepareOperation
+func (d *document) prepareOperation(op *operation) error {
+	for i, p := range op.Parameters {
+		if p.Ref != "" {
+			ref, ok := d.Components.Parameters[strings.TrimPrefix(p.Ref, parameterRef)]
+			if !ok {
+				return fmt.Errorf("unknown parameter %s", p.Ref)
+			}
+			op.Parameters[i], p = ref, ref
+		}
+		if err := d.prepare(p.Schema); err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+	}
+
+	if op.RequestBody != nil {
+		for _, c := range op.RequestBody.Content {
+			if err := d.prepare(c.Schema); err != nil {
+				return fmt.Errorf("request body: %w", err)
+			}
+		}
+	}
+
+	for status, res := range op.Responses {
+		if res.Ref != "" {
+			ref, ok := d.Components.Responses[strings.TrimPrefix(res.Ref, responseRef)]
+			if !ok {
+				return fmt.Errorf("unknown response %s", res.Ref)
+			}
+			op.Responses[status], res = ref, ref
+		}
+		for _, c := range res.Content {
+			if err := d.prepare(c.Schema); err != nil {
+				return fmt.Errorf("response %s: %w", status, err)
+			}
+		}
+	}
+	return nil
+}
+
+// prepare compiles the patterns, references are checked but not followed
+func (d *document) prepare(s *schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		if _, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRef)]; !ok {
+			return fmt.Errorf("unknown schema %s", s.Ref)
+		}
+		return nil
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+	for _, p := range s.Properties {
+		if err := d.prepare(p); err != nil {
+			return err
+		}
+	}
+	return d.prepare(s.Items)
+}
+
+// resolve follows the reference
+func (d *document) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRef)]
+	}
+	return s
+}
+
+// route finds the operation and the path parameters, a path without parameters wins
+// over a template, a template with fewer parameters over one with more
+func (d *document) route(method, path string) (*operation, map[string]string) {
+	method = strings.ToLower(method)
+	segments := strings.Split(path, "/")
+
+	var (
+		found  *operation
+		params map[string]string
+	)
+	for template, item := range d.Paths {
+		op, ok := item[method]
+		if !ok {
+			continue
+		}
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+
+		values := map[string]string{}
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") && segments[i] != "" {
+				values[part[1:len(part)-1]] = segments[i]
+				continue
+			}
+			if part != segments[i] {
+				values = nil
+				break
+			}
+		}
+		if values != nil && (found == nil || len(values) < len(params)) {
+			found, params = op, values
+		}
+	}
+	return found, params
+}
+
+// validateParam converts the value of the parameter by the type of the schema
+func (d *document) validateParam(s *schema, value string, name string) error {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	var v interface{} = value
+	switch s.Type {
+	case "integer", "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: must be %s", name, article(s.Type))
+		}
+		v = n
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: must be a boolean", name)
+		}
+		v = b
+	}
+	return d.validate(s, v, name)
+}
+
+// validate checks the value decoded from JSON, field names the value in the errors
+func (d *document) validate(s *schema, v interface{}, field string) error {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", field)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", field, s.Enum)
+		}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", field)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s: is required", field, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		// лишние поля не запрещены, их просто не проверяем
+		for _, name := range names {
+			if err := d.validate(s.Properties[name], obj[name], field+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", field)
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", field, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			return fmt.Errorf("%s: must have at most %d items", field, *s.MaxItems)
+		}
+		for i, item := range arr {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", field)
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(str) < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters long", field, *s.MinLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			return fmt.Errorf("%s: must match %s", field, s.Pattern)
+		}
+		if !validFormat(s.Format, str) {
+			return fmt.Errorf("%s: must be a %s", field, s.Format)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s.Type == "integer" && n != math.Trunc(n)) {
+			return fmt.Errorf("%s: must be %s", field, article(s.Type))
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s: must be at least %v", field, *s.Minimum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", field)
+		}
+	}
+	return nil
+}
+
+// article
+func article(typ string) string {
+	if typ == "integer" {
+		return "an integer"
+	}
+	return "a " + typ
+}
+
+// validFormat, unknown formats are not checked
+func validFormat(format string, v string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	case "date-or-date-time":
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return true
+		}
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	}
+	return true
+}
@@ -0,0 +1,321 @@
+// Package openapi serves the OpenAPI document of the user API and checks requests against it,
+// in the test mode the responses are checked too, so the handlers can't drift away from the document
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/logger"
+	"github.com/lekan/gophermart/internal/problem"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBody bounds the bodies read for the checks
+const maxBody = 1 << 20
+
+//go:embed openapi.json
+var spec []byte
+
+var (
+	// ErrMediaType is returned for a body of a content type the operation doesn't accept
+	ErrMediaType = errors.New("unsupported content type")
+	// ErrInvalidJSON is returned for a body that is not JSON at all
+	ErrInvalidJSON = errors.New("malformed JSON")
+	// ErrTooLarge is returned for a request body over maxBody
+	ErrTooLarge = errors.New("request body is too large")
+)
+
+var log = logger.New()
+
+// doc, the embedded document is checked by the tests, so it can't be broken at run time
+var doc = mustLoad(spec)
+
+// Serve answers with the document
+func Serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(spec); err != nil {
+		log.Err(err).Msg("openapi write error")
+	}
+}
+
+// Operations lists the documented operations as "METHOD path", sorted
+func Operations() []string {
+	var res []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			res = append(res, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Validate answers 400 to the requests of the documented operations that don't match the document,
+// 415 to a body of a content type the operation doesn't accept
+func Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := readBody(r)
+		if err == nil {
+			err = ValidateRequest(r, body)
+		}
+		if err != nil {
+			log.Info().Err(err).Msgf("%s %s does not match the document", r.Method, r.URL.Path)
+			switch {
+			case errors.Is(err, ErrTooLarge):
+				problem.Status(w, http.StatusRequestEntityTooLarge)
+			case errors.Is(err, ErrMediaType):
+				problem.Write(w, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, err.Error())
+			case errors.Is(err, ErrInvalidJSON):
+				problem.Write(w, http.StatusBadRequest, problem.CodeInvalidJSON, "")
+			default:
+				problem.Write(w, http.StatusBadRequest, problem.CodeValidation, err.Error())
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ValidateResponses is the test mode: the responses of the documented operations are checked
+// after the handler and every mismatch is passed to report, the client gets the response as it is
+func ValidateResponses(report func(*http.Request, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.WriteHeader(http.StatusOK)
+			}
+
+			err := validateResponse(r.Method, r.URL.Path, rec.status, rec.header, rec.body.Bytes(), !rec.overflow)
+			if err != nil {
+				report(r, fmt.Errorf("%s %s: %w", r.Method, r.URL.Path, err))
+			}
+		})
+	}
+}
+
+// LogMismatch logs the response that doesn't match the document
+func LogMismatch(r *http.Request, err error) {
+	log.Error().Err(err).Msg("response does not match the document")
+}
+
+// ValidateRequest checks the parameters and the body of the request, the requests
+// of operations missing from the document are left to the router
+func ValidateRequest(r *http.Request, body []byte) error {
+	op, params := doc.route(r.Method, r.URL.Path)
+	if op == nil {
+		return nil
+	}
+
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		switch p.In {
+		case "path":
+			value = params[p.Name]
+		case "query":
+			value = query.Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+		}
+		// пустое значение обработчики считают отсутствующим
+		if value == "" {
+			if p.Required {
+				return fmt.Errorf("%s parameter %s is required", p.In, p.Name)
+			}
+			continue
+		}
+		if err := doc.validateParam(p.Schema, value, p.Name); err != nil {
+			return fmt.Errorf("%s parameter %w", p.In, err)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+
+	mediaType := mediaTypeOf(r.Header.Get("Content-Type"))
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok && mediaType == "" {
+		// без Content-Type тело проверяется как текст, если операция его принимает, иначе как JSON
+		for _, v := range []string{"text/plain", "application/json"} {
+			if content, ok = op.RequestBody.Content[v]; ok {
+				mediaType = v
+				break
+			}
+		}
+	}
+	if !ok {
+		// диапазон вроде */* принимает тело любого типа как есть, оно проверяется как текст
+		for _, v := range mediaRanges(mediaType) {
+			if content, ok = op.RequestBody.Content[v]; ok {
+				mediaType = "text/plain"
+				break
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%w %q", ErrMediaType, mediaType)
+	}
+	return doc.validateBody(content.Schema, mediaType, body)
+}
+
+// ValidateResponse checks the status, the content type and the body of the response
+func ValidateResponse(method, path string, status int, header http.Header, body []byte) error {
+	return validateResponse(method, path, status, header, body, true)
+}
+
+// validateResponse, the body of a response too large to keep is not checked
+func validateResponse(method, path string, status int, header http.Header, body []byte, checkBody bool) error {
+	op, _ := doc.route(method, path)
+	if op == nil {
+		return nil
+	}
+
+	res, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		res, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+
+	if len(body) == 0 {
+		if len(res.Content) > 0 && checkBody {
+			return fmt.Errorf("status %d must have a body", status)
+		}
+		return nil
+	}
+	if len(res.Content) == 0 {
+		return fmt.Errorf("status %d must not have a body", status)
+	}
+
+	mediaType := mediaTypeOf(header.Get("Content-Type"))
+	content, ok := res.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("content type %q of status %d is not documented", mediaType, status)
+	}
+	if !checkBody {
+		return nil
+	}
+	return doc.validateBody(content.Schema, mediaType, body)
+}
+
+// readBody reads the whole body and puts it back for the handler
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBody {
+		return nil, ErrTooLarge
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return body, nil
+}
+
+// mediaRanges are the ranges the media type falls into, the narrow one first
+func mediaRanges(mediaType string) []string {
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		return []string{mediaType[:i] + "/*", "*/*"}
+	}
+	return []string{"*/*"}
+}
+
+// mediaTypeOf drops the parameters of the content type
+func mediaTypeOf(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// validateBody decodes the body by its content type, bodies neither JSON nor text are not checked
+func (d *document) validateBody(s *schema, mediaType string, body []byte) error {
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return fmt.Errorf("body: %w", ErrInvalidJSON)
+		}
+		return d.validate(s, v, "body")
+	case mediaType == "application/x-ndjson":
+		for i, line := range bytes.Split(body, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var v interface{}
+			if err := json.Unmarshal(line, &v); err != nil {
+				return fmt.Errorf("body line %d: %w", i+1, ErrInvalidJSON)
+			}
+			if err := d.validate(s, v, fmt.Sprintf("body line %d", i+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return d.validate(s, strings.TrimSpace(string(body)), "body")
+	}
+	return nil
+}
+
+// recorder keeps the status, the headers sent with it and the beginning of the body
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.body.Len()+len(b) > maxBody {
+		rec.overflow = true
+	} else {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush keeps the event stream working in the test mode
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,497 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/lekan/gophermart/internal/problem"
+	"github.com/lekan/gophermart/internal/repo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := load(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Paths) == 0 {
+		t.Fatal("no paths")
+	}
+	for _, op := range Operations() {
+		if !strings.Contains(op, " /api/user/") {
+			t.Errorf("%s is not a user route", op)
+		}
+	}
+
+	if _, err = load([]byte(`{"paths":{"/a":{"get":{"responses":{"200":{"$ref":"#/components/responses/Nope"}}}}}}`)); err == nil {
+		t.Error("unknown response reference is accepted")
+	}
+	if _, err = load([]byte(`{"components":{"schemas":{"A":{"properties":{"b":{"$ref":"#/components/schemas/B"}}}}}}`)); err == nil {
+		t.Error("unknown schema reference is accepted")
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		header      map[string]string
+		body        string
+		wantErr     error
+		wantField   string
+	}{
+		{
+			name:        "success test #1",
+			method:      http.MethodPost,
+			target:      "/api/user/register",
+			contentType: "application/json",
+			body:        `{"login":"user","password":"secret"}`,
+		},
+		{
+			name:        "success test #2",
+			method:      http.MethodPost,
+			target:      "/api/user/orders",
+			contentType: "text/plain",
+			body:        "12345678903",
+		},
+		{
+			name:   "success test #3",
+			method: http.MethodPost,
+			target: "/api/user/orders",
+			body:   "12345678903\n",
+		},
+		{
+			name:        "success test #4",
+			method:      http.MethodPost,
+			target:      "/api/user/orders/batch",
+			contentType: "application/json; charset=utf-8",
+			body:        `["12345678903","2377225624"]`,
+		},
+		{
+			name:   "success test #5",
+			method: http.MethodGet,
+			target: "/api/user/transactions?from=2024-01-01&to=2024-02-01T00:00:00Z&limit=10",
+		},
+		{
+			name:   "success test #6",
+			method: http.MethodPost,
+			target: "/api/user/balance/holds/7/capture",
+		},
+		{
+			name:   "success test #7",
+			method: http.MethodGet,
+			target: "/api/admin/catalog?anything=goes",
+		},
+		{
+			name:        "success test #8",
+			method:      http.MethodPost,
+			target:      "/api/user/orders",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "12345678903",
+		},
+		{
+			name:        "missing field",
+			method:      http.MethodPost,
+			target:      "/api/user/login",
+			contentType: "application/json",
+			body:        `{"login":"user"}`,
+			wantField:   "body.password",
+		},
+		{
+			name:        "wrong type",
+			method:      http.MethodPost,
+			target:      "/api/user/balance/withdraw",
+			contentType: "application/json",
+			body:        `{"order":"2377225624","sum":"751"}`,
+			wantField:   "body.sum",
+		},
+		{
+			name:        "malformed json",
+			method:      http.MethodPost,
+			target:      "/api/user/balance/transfer",
+			contentType: "application/json",
+			body:        `{"to":`,
+			wantErr:     ErrInvalidJSON,
+		},
+		{
+			name:        "wrong content type",
+			method:      http.MethodPost,
+			target:      "/api/user/balance/withdraw",
+			contentType: "application/xml",
+			body:        "<order>12345678903</order>",
+			wantErr:     ErrMediaType,
+		},
+		{
+			name:      "empty body",
+			method:    http.MethodPost,
+			target:    "/api/user/orders",
+			wantField: "body is required",
+		},
+		{
+			name:        "batch too large",
+			method:      http.MethodPost,
+			target:      "/api/user/orders/batch",
+			contentType: "application/json",
+			body:        "[" + strings.Repeat(`"12345678903",`, 100) + `"12345678903"]`,
+			wantField:   "body",
+		},
+		{
+			name:      "wrong path parameter",
+			method:    http.MethodPost,
+			target:    "/api/user/catalog/first/redeem",
+			wantField: "id",
+		},
+		{
+			name:      "wrong enum",
+			method:    http.MethodGet,
+			target:    "/api/user/statement?format=xlsx",
+			wantField: "format",
+		},
+		{
+			name:      "wrong date",
+			method:    http.MethodGet,
+			target:    "/api/user/transactions?from=yesterday",
+			wantField: "from",
+		},
+		{
+			name:      "wrong header",
+			method:    http.MethodGet,
+			target:    "/api/user/events",
+			header:    map[string]string{"Last-Event-ID": "abc"},
+			wantField: "Last-Event-ID",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			err := ValidateRequest(r, []byte(tt.body))
+			switch {
+			case tt.wantErr == nil && tt.wantField == "":
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err == nil || !strings.Contains(err.Error(), tt.wantField) {
+					t.Errorf("error = %v, want one about %s", err, tt.wantField)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	problemHeader := http.Header{"Content-Type": {problem.ContentType}}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		header  http.Header
+		body    interface{}
+		wantErr bool
+	}{
+		{
+			name:   "success test #1",
+			method: http.MethodGet,
+			path:   "/api/user/orders",
+			status: http.StatusOK,
+			header: jsonHeader,
+			body: []repo.Orders{
+				{Number: "12345678903", Status: "PROCESSED", Accrual: 500, Credited: 550, UploadedAt: now},
+				{Number: "2377225624", Status: "NEW", UploadedAt: now},
+			},
+		},
+		{
+			name:   "success test #2",
+			method: http.MethodGet,
+			path:   "/api/user/balance",
+			status: http.StatusOK,
+			header: jsonHeader,
+			body: repo.Balance{Current: 500.5, Withdrawn: 42, Held: 10,
+				ExpiringSoon: []repo.ExpiringPoints{{Amount: 20, ExpiresAt: now}}},
+		},
+		{
+			name:   "success test #3",
+			method: http.MethodGet,
+			path:   "/api/user/withdrawals",
+			status: http.StatusOK,
+			header: jsonHeader,
+			body:   []repo.Withdrawals{{Order: "2377225624", Sum: 500, ProcessedAt: now}},
+		},
+		{
+			name:   "success test #4",
+			method: http.MethodPost,
+			path:   "/api/user/balance/holds",
+			status: http.StatusCreated,
+			header: jsonHeader,
+			body:   repo.Hold{ID: 1, Order: "2377225624", Amount: 10, Status: repo.HoldActive, CreatedAt: now, ExpiresAt: now},
+		},
+		{
+			name:   "success test #5",
+			method: http.MethodGet,
+			path:   "/api/user/profile",
+			status: http.StatusOK,
+			header: jsonHeader,
+			body:   repo.Profile{Login: "user", Tier: "bronze", Multiplier: 1, TierHistory: []repo.TierChange{}},
+		},
+		{
+			name:   "success test #6",
+			method: http.MethodGet,
+			path:   "/api/user/referrals",
+			status: http.StatusOK,
+			header: jsonHeader,
+			body:   repo.Referrals{Code: "ABCD2345", Referrals: []repo.Referral{{Referred: "friend", Status: repo.ReferralPending, CreatedAt: now}}},
+		},
+		{
+			name:   "success test #7",
+			method: http.MethodPost,
+			path:   "/api/user/catalog/3/redeem",
+			status: http.StatusCreated,
+			header: jsonHeader,
+			body:   repo.CatalogRedemption{ID: 1, ItemID: 3, Title: "Mug", Quantity: 1, Price: 100, Total: 100, Order: "9000000031", CreatedAt: now},
+		},
+		{
+			name:   "success test #8",
+			method: http.MethodPost,
+			path:   "/api/user/orders",
+			status: http.StatusAccepted,
+			header: jsonHeader,
+		},
+		{
+			name:   "success test #9",
+			method: http.MethodPost,
+			path:   "/api/user/orders",
+			status: http.StatusConflict,
+			header: problemHeader,
+			body:   problem.New(http.StatusConflict, problem.CodeOrderConflict, ""),
+		},
+		{
+			name:    "undocumented status",
+			method:  http.MethodGet,
+			path:    "/api/user/orders",
+			status:  http.StatusTeapot,
+			header:  problemHeader,
+			body:    problem.New(http.StatusTeapot, "", ""),
+			wantErr: true,
+		},
+		{
+			name:    "missing content type",
+			method:  http.MethodGet,
+			path:    "/api/user/withdrawals",
+			status:  http.StatusOK,
+			header:  http.Header{},
+			body:    []repo.Withdrawals{{Order: "2377225624", Sum: 500, ProcessedAt: now}},
+			wantErr: true,
+		},
+		{
+			name:    "wrong status of an order",
+			method:  http.MethodGet,
+			path:    "/api/user/orders",
+			status:  http.StatusOK,
+			header:  jsonHeader,
+			body:    []map[string]interface{}{{"number": "12345678903", "status": "DONE", "uploaded_at": now}},
+			wantErr: true,
+		},
+		{
+			name:    "missing body",
+			method:  http.MethodGet,
+			path:    "/api/user/profile",
+			status:  http.StatusOK,
+			header:  jsonHeader,
+			wantErr: true,
+		},
+		{
+			name:    "error without problem",
+			method:  http.MethodPost,
+			path:    "/api/user/balance/withdraw",
+			status:  http.StatusPaymentRequired,
+			header:  http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			body:    "not enough points",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			switch v := tt.body.(type) {
+			case nil:
+			case string:
+				body = []byte(v)
+			default:
+				var err error
+				if body, err = json.Marshal(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := ValidateResponse(tt.method, tt.path, tt.status, tt.header, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var called bool
+	h := Validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{
+			name:        "success test #1",
+			contentType: "application/json",
+			body:        `{"order":"2377225624","sum":751}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "validation",
+			contentType: "application/json",
+			body:        `{"order":"2377225624"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeValidation,
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `{"order"`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    problem.CodeInvalidJSON,
+		},
+		{
+			name:        "media type",
+			contentType: "text/plain",
+			body:        "2377225624",
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantCode:    problem.CodeUnsupportedMedia,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v", called)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var p problem.Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.wantCode {
+				t.Errorf("code = %s, want %s", p.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		handler  http.HandlerFunc
+		wantErrs int
+	}{
+		{
+			name:   "success test #1",
+			target: "/api/user/orders",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"number":"12345678903","status":"NEW","uploaded_at":"2024-03-01T12:00:00Z"}]`))
+			},
+		},
+		{
+			name:   "success test #2",
+			target: "/api/user/orders",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+		},
+		{
+			name:   "header after body",
+			target: "/api/user/withdrawals",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`[{"order":"2377225624","sum":500,"processed_at":"2024-03-01T12:00:00Z"}]`))
+				w.Header().Add("Content-Type", "application/json")
+			},
+			wantErrs: 1,
+		},
+		{
+			name:   "drift",
+			target: "/api/user/orders",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`[{"order":"12345678903","status":"NEW","uploaded_at":"2024-03-01T12:00:00Z"}]`))
+			},
+			wantErrs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs []error
+			h := ValidateResponses(func(r *http.Request, err error) {
+				errs = append(errs, err)
+			})(tt.handler)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if len(errs) != tt.wantErrs {
+				t.Errorf("mismatches = %v, want %d", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	w := httptest.NewRecorder()
+	Serve(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	var v struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v.OpenAPI, "3.") {
+		t.Errorf("openapi = %s", v.OpenAPI)
+	}
+}
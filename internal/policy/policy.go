@@ -0,0 +1,175 @@
+// Package policy keeps the business rules that risk can change without a new release,
+// they are read from a JSON file over the defaults from the environment and reloaded on demand
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/fraud"
+	"os"
+	"sync"
+	"time"
+)
+
+// Duration is time.Duration written as "24h" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Policy, zero values switch a rule off
+type Policy struct {
+	Withdrawal Withdrawal   `json:"withdrawal"`
+	Fraud      []fraud.Rule `json:"fraud"`
+}
+
+// Withdrawal limits
+type Withdrawal struct {
+	MinSum     float32  `json:"min_sum"`
+	MaxSum     float32  `json:"max_sum"`
+	DailyCap   float32  `json:"daily_cap"`
+	MonthlyCap float32  `json:"monthly_cap"`
+	Cooldown   Duration `json:"cooldown"`
+}
+
+// Violation codes
+const (
+	CodeBelowMin   = "withdrawal_below_min"
+	CodeAboveMax   = "withdrawal_above_max"
+	CodeDailyCap   = "withdrawal_daily_cap"
+	CodeMonthlyCap = "withdrawal_monthly_cap"
+	CodeCooldown   = "withdrawal_cooldown"
+)
+
+// Violation of a rule, Code is stable for clients
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// Usage is what the user has already withdrawn
+type Usage struct {
+	Daily        float32
+	Monthly      float32
+	RegisteredAt time.Time
+	Now          time.Time
+}
+
+// Check returns a *Violation if the withdrawal breaks a rule
+func (w Withdrawal) Check(u Usage, sum float32) error {
+	switch {
+	case w.Cooldown > 0 && !u.RegisteredAt.IsZero() && u.Now.Sub(u.RegisteredAt) < time.Duration(w.Cooldown):
+		return &Violation{CodeCooldown, fmt.Sprintf("withdrawals are allowed %s after registration", time.Duration(w.Cooldown))}
+	case w.MinSum > 0 && sum < w.MinSum:
+		return &Violation{CodeBelowMin, fmt.Sprintf("minimum withdrawal is %.2f", w.MinSum)}
+	case w.MaxSum > 0 && sum > w.MaxSum:
+		return &Violation{CodeAboveMax, fmt.Sprintf("maximum withdrawal is %.2f", w.MaxSum)}
+	case w.DailyCap > 0 && u.Daily+sum > w.DailyCap:
+		return &Violation{CodeDailyCap, fmt.Sprintf("daily withdrawal cap of %.2f is reached", w.DailyCap)}
+	case w.MonthlyCap > 0 && u.Monthly+sum > w.MonthlyCap:
+		return &Violation{CodeMonthlyCap, fmt.Sprintf("monthly withdrawal cap of %.2f is reached", w.MonthlyCap)}
+	}
+	return nil
+}
+
+// Validate
+func (p Policy) Validate() error {
+	w := p.Withdrawal
+	switch {
+	case w.MinSum < 0 || w.MaxSum < 0 || w.DailyCap < 0 || w.MonthlyCap < 0 || w.Cooldown < 0:
+		return errors.New("limits can not be negative")
+	case w.MaxSum > 0 && w.MinSum > w.MaxSum:
+		return errors.New("min_sum is over max_sum")
+	}
+	for _, r := range p.Fraud {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads the file over base, an empty path gives base
+func Load(path string, base Policy) (Policy, error) {
+	p := base
+	// json переиспользует массив среза, base не должен меняться
+	p.Fraud = append([]fraud.Rule(nil), base.Fraud...)
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Policy{}, err
+		}
+		if err = json.Unmarshal(b, &p); err != nil {
+			return Policy{}, fmt.Errorf("policy %s: %w", path, err)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+var (
+	mu       sync.RWMutex
+	current  Policy
+	file     string
+	defaults Policy
+)
+
+// Init loads the policy and remembers where from for Reload
+func Init(path string, base Policy) error {
+	p, err := Load(path, base)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	file, defaults, current = path, base, p
+	return nil
+}
+
+// Reload reads the file again, on error the old policy stays
+func Reload() (Policy, error) {
+	mu.RLock()
+	path, base := file, defaults
+	mu.RUnlock()
+
+	p, err := Load(path, base)
+	if err != nil {
+		return Get(), err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = p
+	return p, nil
+}
+
+// Get returns the policy in force
+func Get() Policy {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
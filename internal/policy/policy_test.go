@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2022, 5, 7, 12, 0, 0, 0, time.UTC)
+	w := Withdrawal{MinSum: 10, MaxSum: 1000, DailyCap: 1500, MonthlyCap: 5000, Cooldown: Duration(24 * time.Hour)}
+
+	tests := []struct {
+		name  string
+		w     Withdrawal
+		usage Usage
+		sum   float32
+		want  string
+	}{
+		{
+			name:  "success test #1",
+			w:     w,
+			usage: Usage{Daily: 400, Monthly: 3000, RegisteredAt: now.Add(-48 * time.Hour), Now: now},
+			sum:   1000,
+		},
+		{
+			name:  "success test #2",
+			w:     Withdrawal{},
+			usage: Usage{Daily: 1e6, Monthly: 1e6, RegisteredAt: now, Now: now},
+			sum:   1e6,
+		},
+		{
+			name:  "registered before the policy",
+			w:     w,
+			usage: Usage{Now: now},
+			sum:   100,
+		},
+		{
+			name:  "cooldown",
+			w:     w,
+			usage: Usage{RegisteredAt: now.Add(-time.Hour), Now: now},
+			sum:   100,
+			want:  CodeCooldown,
+		},
+		{
+			name:  "below min",
+			w:     w,
+			usage: Usage{Now: now},
+			sum:   5,
+			want:  CodeBelowMin,
+		},
+		{
+			name:  "above max",
+			w:     w,
+			usage: Usage{Now: now},
+			sum:   1000.01,
+			want:  CodeAboveMax,
+		},
+		{
+			name:  "daily cap",
+			w:     w,
+			usage: Usage{Daily: 1000, Monthly: 1000, Now: now},
+			sum:   600,
+			want:  CodeDailyCap,
+		},
+		{
+			name:  "monthly cap",
+			w:     w,
+			usage: Usage{Daily: 0, Monthly: 4500, Now: now},
+			sum:   600,
+			want:  CodeMonthlyCap,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.w.Check(tt.usage, tt.sum)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+				return
+			}
+			var v *Violation
+			if !errors.As(err, &v) || v.Code != tt.want {
+				t.Errorf("Check() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	base := Policy{Withdrawal: Withdrawal{MinSum: 1, MaxSum: 100}}
+
+	if err := os.WriteFile(path, []byte(`{"withdrawal": {"max_sum": 500, "cooldown": "72h"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(path, base); err != nil {
+		t.Fatal(err)
+	}
+	got := Get().Withdrawal
+	if got.MinSum != 1 || got.MaxSum != 500 || got.Cooldown != Duration(72*time.Hour) {
+		t.Fatalf("Init() = %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"withdrawal": {"max_sum": 50}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got = Get().Withdrawal; got.MaxSum != 50 || got.Cooldown != 0 {
+		t.Fatalf("Reload() = %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"withdrawal": {"min_sum": 200}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(); err == nil {
+		t.Fatal("Reload() of min_sum over max_sum must fail")
+	}
+	if got = Get().Withdrawal; got.MaxSum != 50 {
+		t.Fatalf("failed Reload() changed the policy to %+v", got)
+	}
+}
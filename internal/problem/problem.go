@@ -0,0 +1,133 @@
+// Package problem writes error responses as RFC 7807 problem details,
+// clients branch on Code, which stays the same between releases
+package problem
+
+import (
+	"encoding/json"
+	"github.com/lekan/gophermart/internal/logger"
+	"net/http"
+)
+
+// ContentType of the error responses
+const ContentType = "application/problem+json"
+
+// Codes of the errors
+const (
+	CodeBadRequest          = "bad_request"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeGone                = "gone"
+	CodeTooLarge            = "payload_too_large"
+	CodeUnsupportedEncoding = "unsupported_encoding"
+	CodeUnsupportedMedia    = "unsupported_media_type"
+	CodeTooManyRequests     = "too_many_requests"
+	CodeInternal            = "internal_error"
+	CodeUnavailable         = "service_unavailable"
+
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidOrderNumber = "invalid_order_number"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeOrderConflict      = "order_conflict"
+	CodeLoginTaken         = "login_taken"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeUnknownTenant      = "unknown_tenant"
+	CodeValidation         = "validation_failed"
+	CodeRecipientNotFound  = "recipient_not_found"
+	CodeTransferLimit      = "transfer_limit_exceeded"
+	CodeIdempotencyKey     = "idempotency_key_reused"
+	CodeVoucherNotFound    = "voucher_not_found"
+	CodeVoucherExpired     = "voucher_expired"
+	CodeVoucherExhausted   = "voucher_exhausted"
+	CodeVoucherRedeemed    = "voucher_already_redeemed"
+	CodeItemNotFound       = "item_not_found"
+	CodeItemUnavailable    = "item_unavailable"
+	CodeOutOfStock         = "out_of_stock"
+	CodeAccountUnderReview = "account_under_review"
+	CodeHoldNotFound       = "hold_not_found"
+	CodeHoldClosed         = "hold_closed"
+)
+
+var log = logger.New()
+
+// Problem is the body of an error response
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// codes are the codes of the statuses that mean one thing everywhere
+var codes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusPaymentRequired:       CodeInsufficientFunds,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusGone:                  CodeGone,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedEncoding,
+	http.StatusUnprocessableEntity:   CodeInvalidOrderNumber,
+	http.StatusTooManyRequests:       CodeTooManyRequests,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// CodeFor returns the code of the status
+func CodeFor(status int) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// New, an empty code is the one of the status
+func New(status int, code string, detail string) Problem {
+	if code == "" {
+		code = CodeFor(status)
+	}
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Write sends the problem, detail must be safe to show to the client
+func Write(w http.ResponseWriter, status int, code string, detail string) {
+	p := New(status, code, detail)
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(&p); err != nil {
+		log.Err(err).Msg("problem encoding error")
+	}
+}
+
+// Status writes the problem with the code of the status, statuses below 400 are written as they are
+func Status(w http.ResponseWriter, status int) {
+	if status < http.StatusBadRequest {
+		w.WriteHeader(status)
+		return
+	}
+	Write(w, status, "", "")
+}
+
+// Internal logs err and answers 500 without telling the client anything about it
+func Internal(w http.ResponseWriter, err error) {
+	if err != nil {
+		log.Err(err).Msg("internal error")
+	}
+	Write(w, http.StatusInternalServerError, CodeInternal, "")
+}
@@ -0,0 +1,86 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		code     string
+		detail   string
+		wantCode string
+	}{
+		{name: "success test #1", status: http.StatusConflict, code: CodeOrderConflict, wantCode: "order_conflict"},
+		{name: "success test #2", status: http.StatusPaymentRequired, wantCode: "insufficient_funds"},
+		{name: "success test #3", status: http.StatusUnprocessableEntity, wantCode: "invalid_order_number"},
+		{name: "success test #4", status: http.StatusBadGateway, wantCode: "internal_error"},
+		{name: "success test #5", status: http.StatusTeapot, wantCode: "bad_request"},
+		{name: "success test #6", status: http.StatusBadRequest, code: CodeValidation, detail: "wrong campaign: name is required", wantCode: "validation_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "application/json")
+			Write(w, tt.status, tt.code, tt.detail)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != ContentType {
+				t.Errorf("Content-Type = %q, want %q", got, ContentType)
+			}
+
+			var p Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			want := Problem{Type: "about:blank", Title: http.StatusText(tt.status), Status: tt.status, Code: tt.wantCode, Detail: tt.detail}
+			if p != want {
+				t.Errorf("problem = %+v, want %+v", p, want)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantBody bool
+	}{
+		{name: "success test #1", status: http.StatusAccepted, wantBody: false},
+		{name: "success test #2", status: http.StatusNoContent, wantBody: false},
+		{name: "success test #3", status: http.StatusNotFound, wantBody: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Status(w, tt.status)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Body.Len() > 0; got != tt.wantBody {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInternal(t *testing.T) {
+	w := httptest.NewRecorder()
+	Internal(w, errors.New(`pq: duplicate key value violates unique constraint "users_pkey"`))
+
+	var p Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != CodeInternal || p.Detail != "" {
+		t.Errorf("problem = %+v, the error must not be sent", p)
+	}
+}
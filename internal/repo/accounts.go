@@ -2,15 +2,19 @@ package repo
 
 import (
 	"context"
+	"database/sql"
 	_ "embed"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/golang-jwt/jwt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/accrual"
+	"github.com/lekan/gophermart/internal/codegen"
 	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/fraud"
 	"github.com/lekan/gophermart/internal/logger"
 	"github.com/lekan/gophermart/internal/luhn"
+	"github.com/lekan/gophermart/internal/resilience"
+	"github.com/lekan/gophermart/internal/tiers"
 	_ "github.com/lib/pq"
 	"github.com/omeid/pgerror"
 	"golang.org/x/sync/errgroup"
@@ -26,8 +30,9 @@ type Token struct {
 }
 
 type Credentials struct {
-	Login    string `json:"login" db:"username"`
-	Password string `json:"password" db:"password"`
+	Login        string `json:"login" db:"username"`
+	Password     string `json:"password" db:"password"`
+	ReferralCode string `json:"referral_code,omitempty" db:"-"`
 }
 
 var db *sqlx.DB
@@ -43,6 +48,54 @@ var orders string
 //go:embed withdrawals.txt
 var withdrawals string
 
+//go:embed events.txt
+var events string
+
+//go:embed webhooks.txt
+var webhooks string
+
+//go:embed ledger.txt
+var ledger string
+
+//go:embed reconcile.txt
+var reconcile string
+
+//go:embed lots.txt
+var lots string
+
+//go:embed reversals.txt
+var reversals string
+
+//go:embed holds.txt
+var holds string
+
+//go:embed transfers.txt
+var transfers string
+
+//go:embed tiers.txt
+var tiersSchema string
+
+//go:embed campaigns.txt
+var campaignsSchema string
+
+//go:embed referrals.txt
+var referrals string
+
+//go:embed vouchers.txt
+var vouchers string
+
+//go:embed withdrawal_policy.txt
+var withdrawalPolicy string
+
+//go:embed fraud.txt
+var fraudSchema string
+
+//go:embed catalog.txt
+var catalog string
+
+//go:embed tenants.txt
+var tenantsSchema string
+
 // New
 func New(databaseURI string) error {
 	db = sqlx.MustConnect("postgres", databaseURI)
@@ -50,26 +103,87 @@ func New(databaseURI string) error {
 	db.MustExec(schema)
 	db.MustExec(orders)
 	db.MustExec(withdrawals)
+	db.MustExec(events)
+	db.MustExec(webhooks)
+	db.MustExec(ledger)
+	db.MustExec(reconcile)
+	db.MustExec(lots)
+	db.MustExec(reversals)
+	db.MustExec(holds)
+	db.MustExec(transfers)
+	db.MustExec(tiersSchema)
+	db.MustExec(campaignsSchema)
+	db.MustExec(referrals)
+	db.MustExec(vouchers)
+	db.MustExec(withdrawalPolicy)
+	db.MustExec(fraudSchema)
+	db.MustExec(catalog)
+	db.MustExec(tenantsSchema)
+
+	if err := LoadTenants(context.Background()); err != nil {
+		return err
+	}
+
+	c := config.Get()
+	table, err := tiers.Parse(c.Tiers)
+	if err != nil {
+		return err
+	}
+	tierTable = table
+
+	client, err := newAccrualClient(c.AccrualSystemAddress)
+	if err != nil {
+		return err
+	}
+	SetAccrualClient(client)
 
 	log.Info().Msg("create db is done...")
 	return db.Ping()
 }
 
-// Signup
+// ErrLoginTaken
+var ErrLoginTaken = errors.New("login is taken")
+
+// Signup registers the user with the tenant of the context, the referral code of the inviting user is optional.
+// Logins stay unique across tenants.
 func Signup(ctx context.Context, creds *Credentials) error {
+	code, err := codegen.New(referralCodeLength)
+	if err != nil {
+		return err
+	}
 
-	_, err := db.ExecContext(ctx, `INSERT INTO users(username, password) VALUES ($1, $2)`, creds.Login, creds.Password)
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("409 %w", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	_, err = tx.ExecContext(ctx, `INSERT INTO users(username, password, referral_code, tenant_id) VALUES ($1, $2, $3, $4)`,
+		creds.Login, creds.Password, code, tenantID(ctx))
+	if err != nil {
+		// код приглашения тоже уникален, занятым считается только логин
+		if pqErr := pgerror.UniqueViolation(err); pqErr != nil && (pqErr.Constraint == "users_pkey" || pqErr.Constraint == "users_username_key") {
+			return ErrLoginTaken
+		}
+		log.Err(err).Msg("signup error")
+		return err
+	}
+
+	if creds.ReferralCode != "" {
+		if err = addReferral(ctx, tx, creds.Login, creds.ReferralCode); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Signin
 func Signin(ctx context.Context, creds *Credentials) error {
 	temp := &Credentials{}
-	if err := db.GetContext(ctx, temp, `SELECT username, password FROM users WHERE username = $1`, creds.Login); err != nil {
+	err := db.GetContext(ctx, temp, `SELECT username, password FROM users WHERE username = $1 AND tenant_id = $2`,
+		creds.Login, tenantID(ctx))
+	if err != nil {
 		return err
 	}
 
@@ -84,31 +198,36 @@ type Order struct {
 }
 
 // worker
-func worker(url string, orderCh chan Order) error {
+func worker(ctx context.Context, number string, orderCh chan Order) error {
+	client, err := clientFor(ctx)
+	if err != nil {
+		log.Err(err).Msg("accrual client error")
+		return err
+	}
+
 	var order Order
 	for i := 0; i < 5; i++ {
-		res, err := http.Get(url)
-		if err != nil {
-			log.Err(err).Msg("goroutine get error")
-			return err
-		}
+		res, err := client.GetOrder(ctx, number)
 
-		log.Info().Msgf("in worker: %s", res.Status)
-		if res.StatusCode == http.StatusNoContent {
+		var rateLimit *accrual.RateLimitError
+		if errors.Is(err, accrual.ErrNotRegistered) || errors.As(err, &rateLimit) {
+			log.Info().Msgf("in worker: %v", err)
 			order = Order{}
 			break
 		}
-
-		defer res.Body.Close()
-
-		if res.StatusCode == http.StatusOK {
-			if err = json.NewDecoder(res.Body).Decode(&order); err != nil {
-				log.Err(err).Msg("in goroutine json error")
-				return err
-			}
+		if errors.Is(err, resilience.ErrOpen) || errors.Is(err, context.DeadlineExceeded) {
+			// заказ останется NEW и будет опрошен позже
+			log.Info().Msgf("accrual system is unavailable: %v", err)
+			return nil
+		}
+		if err != nil {
+			// заказ уже сохранен, ошибка системы начислений не ошибка загрузки
+			log.Err(err).Msgf("accrual request of %s error, the order is left to the poller", number)
+			return nil
 		}
 
-		if order.Status == "PROCESSED" || order.Status == "INVALID" {
+		order = Order{OrderID: res.Order, Status: res.Status, Accrual: res.Accrual}
+		if res.Final() {
 			break
 		}
 	}
@@ -121,21 +240,107 @@ func PostOrder(ctx context.Context, login string, orderID []byte) (int, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	number, err := strconv.Atoi(string(orderID))
-	if err != nil {
-		log.Err(err).Msg("order must be a number")
+	statusCode, err := addOrder(ctx, login, string(orderID))
+	if err != nil || statusCode != http.StatusAccepted {
+		return statusCode, err
+	}
+
+	// с колбэком от системы начислений опрашиваем только просроченные заказы
+	if TenantFrom(ctx).CallbackSecret() != "" {
+		return http.StatusAccepted, nil
+	}
+
+	if err = processOrders(ctx, []string{string(orderID)}); err != nil {
 		return http.StatusInternalServerError, err
 	}
 
-	ok := luhn.Valid(number)
-	if !ok {
+	return http.StatusAccepted, nil
+}
+
+// OrderResult
+type OrderResult struct {
+	Order  string `json:"order"`
+	Status int    `json:"status"`
+	Result string `json:"result"`
+}
+
+// PostOrders registers a batch of order numbers with the same rules as PostOrder
+// and queues all accepted numbers for accrual together.
+func PostOrders(ctx context.Context, login string, orderIDs []string) ([]OrderResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]OrderResult, 0, len(orderIDs))
+	accepted := make([]string, 0, len(orderIDs))
+
+	for _, orderID := range orderIDs {
+		statusCode, err := addOrder(ctx, login, orderID)
+		if err != nil && statusCode == http.StatusInternalServerError {
+			log.Err(err).Msgf("batch order %s error", orderID)
+		}
+
+		results = append(results, OrderResult{
+			Order:  orderID,
+			Status: statusCode,
+			Result: orderResult(statusCode),
+		})
+
+		if statusCode == http.StatusAccepted {
+			accepted = append(accepted, orderID)
+		}
+	}
+
+	if len(accepted) == 0 || TenantFrom(ctx).CallbackSecret() != "" {
+		return results, nil
+	}
+
+	if err := processOrders(ctx, accepted); err != nil {
+		log.Err(err).Msg("batch accrual error")
+	}
+
+	return results, nil
+}
+
+// orderResult
+func orderResult(statusCode int) string {
+	switch statusCode {
+	case http.StatusAccepted:
+		return "accepted"
+	case http.StatusOK:
+		return "already uploaded"
+	case http.StatusConflict:
+		return "uploaded by another user"
+	case http.StatusUnprocessableEntity:
+		return "invalid order number"
+	default:
+		return "internal error"
+	}
+}
+
+// addOrder checks the order number and stores it as NEW, the status code follows POST /api/user/orders.
+// Every attempt is recorded for the velocity rules.
+func addOrder(ctx context.Context, login string, orderID string) (int, error) {
+	statusCode, err := storeOrder(ctx, login, orderID)
+	recordAttempt(ctx, login, orderID, statusCode)
+	return statusCode, err
+}
+
+// storeOrder
+func storeOrder(ctx context.Context, login string, orderID string) (int, error) {
+	number, err := strconv.Atoi(orderID)
+	if err != nil {
+		log.Info().Msgf("order %q is not a number", orderID)
+		return http.StatusUnprocessableEntity, nil
+	}
+
+	if TenantFrom(ctx).LuhnCheck && !luhn.Valid(number) {
 		log.Info().Msg("wrong order number format")
 		return http.StatusUnprocessableEntity, nil
 	}
 
 	var other string
 
-	if err := db.GetContext(ctx, &other, `SELECT username FROM orders WHERE order_id=$1`, string(orderID)); err != nil {
+	if err := db.GetContext(ctx, &other, `SELECT username FROM orders WHERE order_id=$1 AND tenant_id=$2`, orderID, tenantID(ctx)); err != nil {
 		if errors.Is(err, pgerror.NoDataFound(err)) {
 			log.Err(err).Msg("its ok")
 		}
@@ -152,9 +357,9 @@ func PostOrder(ctx context.Context, login string, orderID []byte) (int, error) {
 	}
 
 	_, err = db.ExecContext(ctx, `INSERT INTO orders
-    (order_id, username, status, uploaded_at) 
-    VALUES ($1, $2, $3, $4);`,
-		string(orderID), login, "NEW", time.Now().Format(time.RFC3339))
+    (order_id, username, status, uploaded_at, tenant_id) 
+    VALUES ($1, $2, $3, now(), $4);`,
+		orderID, login, "NEW", tenantID(ctx))
 	if err != nil {
 		if errors.Is(err, pgerror.UniqueViolation(err)) {
 			log.Err(err).Msg("Unique Violation")
@@ -164,61 +369,187 @@ func PostOrder(ctx context.Context, login string, orderID []byte) (int, error) {
 		return http.StatusInternalServerError, err
 	}
 
+	if err = publishEvent(ctx, db, login, EventOrder, Orders{Number: orderID, Status: "NEW", UploadedAt: time.Now()}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusAccepted, nil
+}
+
+// processOrders asks the accrual system about every order at once and stores the answers
+func processOrders(ctx context.Context, orderIDs []string) error {
 	errGr, _ := errgroup.WithContext(ctx)
 
-	url := config.GetAccrualSystemAddress() + "/api/orders/" + string(orderID)
-	orderCh := make(chan Order, 1)
+	orderCh := make(chan Order, len(orderIDs))
 
-	errGr.Go(func() error {
-		return worker(url, orderCh)
-	})
+	for _, orderID := range orderIDs {
+		orderID := orderID
+		errGr.Go(func() error {
+			return worker(ctx, orderID, orderCh)
+		})
+	}
 
-	err = errGr.Wait()
-	if err != nil {
-		return http.StatusInternalServerError, err
+	err := errGr.Wait()
+	close(orderCh)
+
+	for order := range orderCh {
+		log.Info().Msgf("%+v", order)
+		if order.OrderID == "" {
+			continue
+		}
+		if _, errUpd := ApplyAccrual(ctx, order); errUpd != nil && !errors.Is(errUpd, ErrNotFound) {
+			return errUpd
+		}
 	}
 
-	order := Order{}
-	order = <-orderCh
+	return err
+}
 
-	log.Info().Msgf("%+v", order)
+// ApplyAccrual stores the answer of the accrual system, both the poller and the callback come here.
+// Final and repeated statuses are skipped, so applying the same answer twice changes nothing.
+func ApplyAccrual(ctx context.Context, order Order) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Err(err).Msg("begin transaction error")
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var login string
+	err = tx.GetContext(ctx, &login, `
+UPDATE orders SET status=$1, accrual=$2, uploaded_at=now() 
+WHERE order_id=$3 AND tenant_id=$4 AND status NOT IN ('PROCESSED', 'INVALID') AND status <> $1 
+RETURNING username;`,
+		order.Status, order.Accrual, order.OrderID, tenantID(ctx))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Err(err).Msg("database update error")
+			return false, err
+		}
 
-	if order.Status == "PROCESSED" {
-		_, err = db.ExecContext(ctx, `UPDATE orders SET status=$1, accrual=$2, uploaded_at=$3 WHERE order_id=$4 AND username=$5;`,
-			order.Status, order.Accrual, time.Now().Format(time.RFC3339), order.OrderID, login)
+		var exists bool
+		err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM orders WHERE order_id=$1 AND tenant_id=$2)`,
+			order.OrderID, tenantID(ctx))
 		if err != nil {
-			log.Err(err).Msg("database update error")
-			return http.StatusInternalServerError, err
+			log.Err(err).Msg("order lookup error")
+			return false, err
 		}
+		if !exists {
+			return false, ErrNotFound
+		}
+		return false, nil
+	}
 
-		_, err = db.ExecContext(ctx, `UPDATE users SET balance=balance+$1 WHERE username=$2`, order.Accrual, login)
+	if order.Status == "PROCESSED" {
+		_, err = tx.ExecContext(ctx, `UPDATE users SET balance=balance+$1 WHERE username=$2`, order.Accrual, login)
 		if err != nil {
 			log.Err(err).Msg("user balance update error")
-			return http.StatusInternalServerError, err
+			return false, err
+		}
+
+		if err = addLot(ctx, tx, login, "accrual", order.OrderID, order.Accrual); err != nil {
+			return false, err
 		}
 
-	} else {
-		_, err = db.ExecContext(ctx, `UPDATE orders SET status=$1, uploaded_at=$2 WHERE order_id=$3 AND username=$4`, order.Status, time.Now().Format(time.RFC3339), order.OrderID, login)
+		// бонусы уровня и кампаний считаются по уровню до заказа, пересчёт уровня идёт после
+		tier, err := orderTier(ctx, tx, login, order)
 		if err != nil {
-			log.Err(err).Msg("database update error")
-			return http.StatusInternalServerError, err
+			return false, err
+		}
+
+		if err = applyTierBonus(ctx, tx, login, order, tier); err != nil {
+			return false, err
+		}
+
+		if err = applyCampaigns(ctx, tx, login, order, tier); err != nil {
+			return false, err
+		}
+
+		if err = applyReferral(ctx, tx, login, order); err != nil {
+			return false, err
+		}
+
+		if err = publishBalance(ctx, tx, login); err != nil {
+			return false, err
 		}
 	}
 
-	return http.StatusAccepted, nil
+	err = publishEvent(ctx, tx, login, EventOrder, Orders{
+		Number:     order.OrderID,
+		Status:     order.Status,
+		Accrual:    order.Accrual,
+		UploadedAt: time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	switch order.Status {
+	case "PROCESSED":
+		err = enqueueWebhook(ctx, tx, WebhookOrderProcessed, orderWebhook{Login: login, Order: order})
+	case "INVALID":
+		err = enqueueWebhook(ctx, tx, WebhookOrderInvalid, orderWebhook{Login: login, Order: order})
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PollStaleOrders asks the accrual system about orders that got no answer before the deadline,
+// the orders of every tenant go to the accrual system of the tenant
+func PollStaleOrders(ctx context.Context) error {
+	stale := []struct {
+		OrderID  string `db:"order_id"`
+		TenantID string `db:"tenant_id"`
+	}{}
+	err := db.SelectContext(ctx, &stale, `
+SELECT order_id, tenant_id FROM orders 
+WHERE status NOT IN ('PROCESSED', 'INVALID') AND uploaded_at < now() - make_interval(secs => $1) 
+ORDER BY uploaded_at 
+LIMIT 100;`, config.GetAccrualCallbackDeadline().Seconds())
+	if err != nil {
+		log.Err(err).Msg("stale orders error")
+		return err
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	byTenant := map[string][]string{}
+	for _, o := range stale {
+		byTenant[o.TenantID] = append(byTenant[o.TenantID], o.OrderID)
+	}
+
+	log.Info().Msgf("polling %d stale orders", len(stale))
+	for id, orderIDs := range byTenant {
+		if err = processOrders(withTenantID(ctx, id), orderIDs); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Balance
 type Balance struct {
-	Current   float32 `json:"current" db:"balance"`
-	Withdrawn float32 `json:"withdrawn" db:"withdrawn"`
+	Current      float32          `json:"current" db:"balance"`
+	Withdrawn    float32          `json:"withdrawn" db:"withdrawn"`
+	Held         float32          `json:"held,omitempty" db:"held"`
+	ExpiringSoon []ExpiringPoints `json:"expiring_soon,omitempty" db:"-"`
 }
 
 // GetBalance
 func GetBalance(ctx context.Context, login string) (Balance, error) {
 	res := Balance{}
 	log.Info().Msgf("balance login: %s", login)
-	if err := db.GetContext(ctx, &res, `SELECT balance, withdrawn FROM users WHERE username = $1`, login); err != nil {
+	err := db.GetContext(ctx, &res, `SELECT balance, withdrawn, held FROM users WHERE username = $1 AND tenant_id = $2`,
+		login, tenantID(ctx))
+	if err != nil {
 		return Balance{}, err
 	}
 	return res, nil
@@ -229,18 +560,21 @@ type Withdrawals struct {
 	Order       string    `json:"order" db:"order_id"`
 	Sum         float32   `json:"sum" db:"withdraw_sum"`
 	ProcessedAt time.Time `json:"processed_at" db:"processed_at"`
+	Reversed    float32   `json:"reversed,omitempty" db:"reversed"`
 }
 
 // GetWithdrawals
 func GetWithdrawals(ctx context.Context, login string) ([]Withdrawals, error) {
 	withdrawals := []Withdrawals{}
-	rows, err := db.QueryxContext(ctx, `SELECT order_id, withdraw_sum, processed_at FROM withdrawals WHERE username = $1`, login)
+	rows, err := db.QueryxContext(ctx, `
+SELECT order_id, withdraw_sum, processed_at, reversed FROM withdrawals 
+WHERE username = $1 AND tenant_id = $2;`, login, tenantID(ctx))
 	if err != nil {
 		return nil, err
 	}
 	for rows.Next() {
 		var v Withdrawals
-		err = rows.Scan(&v.Order, &v.Sum, &v.ProcessedAt)
+		err = rows.Scan(&v.Order, &v.Sum, &v.ProcessedAt, &v.Reversed)
 		if err != nil {
 			return nil, err
 		}
@@ -264,6 +598,7 @@ type Orders struct {
 	Number     string    `json:"number" db:"order_id"`
 	Status     string    `json:"status,omitempty" db:"status"`
 	Accrual    float32   `json:"accrual,omitempty" db:"accrual"`
+	Credited   float32   `json:"credited,omitempty" db:"credited"`
 	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`
 }
 
@@ -271,7 +606,9 @@ type Orders struct {
 func GetOrders(ctx context.Context, login string) ([]Orders, error) {
 	orders := []Orders{}
 
-	rows, err := db.QueryxContext(ctx, `SELECT order_id, status, accrual, uploaded_at FROM orders WHERE username = $1`, login)
+	rows, err := db.QueryxContext(ctx, `
+SELECT order_id, status, accrual, accrual + tier_bonus, uploaded_at FROM orders 
+WHERE username = $1 AND tenant_id = $2;`, login, tenantID(ctx))
 	if err != nil {
 		log.Err(err).Msg("in GetOrder query error")
 		return nil, err
@@ -279,7 +616,7 @@ func GetOrders(ctx context.Context, login string) ([]Orders, error) {
 
 	for rows.Next() {
 		var v Orders
-		err = rows.Scan(&v.Number, &v.Status, &v.Accrual, &v.UploadedAt)
+		err = rows.Scan(&v.Number, &v.Status, &v.Accrual, &v.Credited, &v.UploadedAt)
 		if err != nil {
 			log.Err(err).Msg("in GetOrders scan error")
 			return nil, err
@@ -314,46 +651,134 @@ func Withdraw(ctx context.Context, login string, wdraw *Wdraw) (int, error) {
 	order := wdraw.Order
 	withdraw := wdraw.Sum
 
-	number, err := strconv.Atoi(order)
+	if statusCode, err := checkWithdraw(ctx, order, withdraw); statusCode != http.StatusOK {
+		return statusCode, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		log.Err(err).Msg("order must to be a number")
+		log.Err(err).Msg("begin transaction error")
 		return http.StatusInternalServerError, err
 	}
+	defer tx.Rollback()
 
-	ok := luhn.Valid(number)
+	action, err := restriction(ctx, tx, login, fraud.OpWithdraw)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if action == fraud.Review {
+		_, statusCode, err := holdForReview(ctx, tx, login, order, withdraw)
+		if statusCode != http.StatusAccepted {
+			return statusCode, err
+		}
+		if err = tx.Commit(); err != nil {
+			log.Err(err).Msg("withdraw commit error")
+			return http.StatusInternalServerError, err
+		}
+		return statusCode, nil
+	}
+
+	if statusCode, err := withdrawTx(ctx, tx, login, order, withdraw); statusCode != http.StatusOK {
+		return statusCode, err
+	}
 
-	if !ok {
+	if err = tx.Commit(); err != nil {
+		log.Err(err).Msg("withdraw commit error")
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// checkWithdraw validates the order number with the Luhn policy of the tenant and the sum,
+// the numbers of catalog redemptions are not for users
+func checkWithdraw(ctx context.Context, order string, withdraw float32) (int, error) {
+	number, err := strconv.Atoi(order)
+	if err != nil {
+		log.Info().Msgf("order %q is not a number", order)
+		return http.StatusUnprocessableEntity, nil
+	}
+
+	if TenantFrom(ctx).LuhnCheck && !luhn.Valid(number) {
 		return http.StatusUnprocessableEntity, nil
 	}
 
-	balance, err := GetBalance(ctx, login)
+	if isRedemptionOrder(order) {
+		log.Info().Msgf("order %s is reserved for redemptions", order)
+		return http.StatusUnprocessableEntity, ErrReservedOrder
+	}
+
+	if withdraw <= 0 {
+		log.Info().Msg("withdraw sum must be positive")
+		return http.StatusBadRequest, nil
+	}
+
+	return http.StatusOK, nil
+}
+
+// withdrawTx debits the balance and records the withdrawal inside the transaction
+func withdrawTx(ctx context.Context, tx *sqlx.Tx, login string, order string, withdraw float32) (int, error) {
+	balance := Balance{}
+	err := tx.GetContext(ctx, &balance, `SELECT balance, withdrawn FROM users WHERE username = $1 AND tenant_id = $2 FOR UPDATE`,
+		login, tenantID(ctx))
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 
+	if statusCode, err := checkWithdrawalPolicy(ctx, tx, login, order, withdraw); statusCode != http.StatusOK {
+		return statusCode, err
+	}
+
 	if balance.Current < withdraw {
 		return http.StatusPaymentRequired, nil
 	}
 
-	balance.Current = balance.Current - withdraw
-	balance.Withdrawn = balance.Withdrawn + withdraw
+	takes, err := consumeLots(ctx, tx, login, balance.Current, withdraw)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
 
-	_, errUser := db.ExecContext(ctx, `
+	_, errUser := tx.ExecContext(ctx, `
 UPDATE users 
-SET balance = $1, withdrawn = $2 
-WHERE username = $3;`, balance.Current, balance.Withdrawn, login)
+SET balance = balance - $1, withdrawn = withdrawn + $1 
+WHERE username = $2;`, withdraw, login)
 	if errUser != nil {
 		log.Err(errUser).Msg("user balance update error")
 		return http.StatusInternalServerError, errUser
 	}
 
-	_, errWdwl := db.ExecContext(ctx, `
-INSERT INTO withdrawals(username, order_id, withdraw_sum, processed_at) 
-VALUES ($1, $2, $3, $4);`, login, order, withdraw, time.Now().Format(time.RFC3339))
+	// время ставит база, как и в журнале, чтобы лента операций шла по одним часам
+	var inserted struct {
+		ID          int       `db:"operation_id"`
+		ProcessedAt time.Time `db:"processed_at"`
+	}
+	errWdwl := tx.GetContext(ctx, &inserted, `
+INSERT INTO withdrawals(username, order_id, withdraw_sum, processed_at, tenant_id) 
+VALUES ($1, $2, $3, now(), $4) 
+RETURNING operation_id, processed_at;`, login, order, withdraw, tenantID(ctx))
 	if errWdwl != nil {
+		if errors.Is(errWdwl, pgerror.UniqueViolation(errWdwl)) {
+			return http.StatusConflict, ErrOrderPaid
+		}
 		log.Err(errWdwl).Msg("withdrawals error")
 		return http.StatusInternalServerError, errWdwl
 	}
 
+	if err = recordWithdrawalLots(ctx, tx, inserted.ID, takes); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	err = enqueueWebhook(ctx, tx, WebhookWithdrawalCreate, withdrawalWebhook{
+		Login:       login,
+		Withdrawals: Withdrawals{Order: order, Sum: withdraw, ProcessedAt: inserted.ProcessedAt},
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err = publishBalance(ctx, tx, login); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
 	return http.StatusOK, nil
 }
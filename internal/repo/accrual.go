@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"context"
+	"github.com/lekan/gophermart/internal/accrual"
+)
+
+var accrualClient accrual.Client
+
+// SetAccrualClient replaces the accrual system client, tests use it for fakes
+func SetAccrualClient(c accrual.Client) {
+	accrualClient = c
+}
+
+// GetAccrualStats reports the state of the guarded client of the tenant
+func GetAccrualStats(ctx context.Context) (accrual.Stats, bool) {
+	client, err := clientFor(ctx)
+	if err != nil {
+		return accrual.Stats{}, false
+	}
+	guarded, ok := client.(interface{ Stats() accrual.Stats })
+	if !ok {
+		return accrual.Stats{}, false
+	}
+	return guarded.Stats(), true
+}
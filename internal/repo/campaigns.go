@@ -0,0 +1,268 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/campaigns"
+	"time"
+)
+
+// LedgerCampaignBonus is credited by promotional campaigns
+const LedgerCampaignBonus = "campaign_bonus"
+
+// ErrBadCampaign
+var ErrBadCampaign = errors.New("wrong campaign")
+
+// CampaignPreview is the effect a campaign would have had on the orders processed in its window
+type CampaignPreview struct {
+	Orders  int                `json:"orders"`
+	Users   int                `json:"users"`
+	Total   float32            `json:"total"`
+	Bonuses []PreviewedBonus   `json:"bonuses"`
+	Draft   campaigns.Campaign `json:"campaign"`
+}
+
+// PreviewedBonus
+type PreviewedBonus struct {
+	Login  string  `json:"login"`
+	Order  string  `json:"order"`
+	Amount float32 `json:"amount"`
+}
+
+// previewLimit is how many bonuses the preview lists
+const previewLimit = 100
+
+const campaignColumns = `campaign_id, name, starts_at, ends_at, first_order, tiers, order_prefix, 
+multiplier, fixed_bonus, cap_per_user, active, created_at`
+
+// applyCampaigns credits the bonuses of running campaigns of the tenant for a processed order,
+// tier is the one the user had before the order like for the tier bonus
+func applyCampaigns(ctx context.Context, tx *sqlx.Tx, login string, order Order, tier string) error {
+	list := []campaigns.Campaign{}
+	err := tx.SelectContext(ctx, &list, `SELECT `+campaignColumns+` FROM campaigns 
+WHERE tenant_id = $1 AND active AND starts_at <= now() AND ends_at > now();`, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("running campaigns error")
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+
+	o, err := campaignOrder(ctx, tx, login, order, tier)
+	if err != nil {
+		return err
+	}
+
+	awarded, err := awardedBonuses(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+
+	return campaigns.Apply(ctx, campaignStore{tx: tx, login: login, order: order.OrderID}, campaigns.Evaluate(list, o, awarded))
+}
+
+// campaignStore awards the bonuses of one order inside the transaction
+type campaignStore struct {
+	tx    *sqlx.Tx
+	login string
+	order string
+}
+
+// Award, a processed order can come again from the poller and the callback, only the first one is rewarded
+func (s campaignStore) Award(ctx context.Context, b campaigns.Bonus) (bool, error) {
+	res, err := s.tx.ExecContext(ctx, `
+INSERT INTO campaign_bonuses(campaign_id, username, order_id, amount) 
+VALUES ($1, $2, $3, $4) 
+ON CONFLICT (campaign_id, order_id) DO NOTHING;`, b.CampaignID, s.login, s.order, b.Amount)
+	if err != nil {
+		log.Err(err).Msg("campaign bonus insert error")
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Credit
+func (s campaignStore) Credit(ctx context.Context, b campaigns.Bonus) error {
+	return postEntry(ctx, s.tx, s.login, LedgerCampaignBonus, b.Amount, s.order, fmt.Sprintf("campaign #%d", b.CampaignID))
+}
+
+// campaignOrder collects what the campaign rules need to know about the order
+func campaignOrder(ctx context.Context, q sqlx.QueryerContext, login string, order Order, tier string) (campaigns.Order, error) {
+	o := campaigns.Order{Number: order.OrderID, Accrual: order.Accrual, Tier: tier, ProcessedAt: time.Now()}
+
+	var processed int
+	err := sqlx.GetContext(ctx, q, &processed, `
+SELECT COUNT(*) FROM orders WHERE username = $1 AND status = 'PROCESSED' AND order_id <> $2`, login, order.OrderID)
+	if err != nil {
+		log.Err(err).Msg("processed orders count error")
+		return o, err
+	}
+	o.FirstOrder = processed == 0
+	return o, nil
+}
+
+// awardedBonuses sums what the user got from each campaign
+func awardedBonuses(ctx context.Context, q sqlx.QueryerContext, login string) (map[int]float32, error) {
+	rows, err := q.QueryxContext(ctx, `
+SELECT campaign_id, SUM(amount) FROM campaign_bonuses 
+WHERE username = $1 
+GROUP BY campaign_id;`, login)
+	if err != nil {
+		log.Err(err).Msg("awarded bonuses error")
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[int]float32{}
+	for rows.Next() {
+		var id int
+		var sum float32
+		if err = rows.Scan(&id, &sum); err != nil {
+			return nil, err
+		}
+		res[id] = sum
+	}
+	return res, rows.Err()
+}
+
+// CreateCampaign
+func CreateCampaign(ctx context.Context, c campaigns.Campaign) (campaigns.Campaign, error) {
+	if err := c.Validate(); err != nil {
+		return campaigns.Campaign{}, fmt.Errorf("%w: %v", ErrBadCampaign, err)
+	}
+
+	var res campaigns.Campaign
+	err := db.GetContext(ctx, &res, `
+INSERT INTO campaigns(name, starts_at, ends_at, first_order, tiers, order_prefix, multiplier, fixed_bonus, cap_per_user, active, tenant_id) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) 
+RETURNING `+campaignColumns+`;`,
+		c.Name, c.StartsAt, c.EndsAt, c.FirstOrder, c.Tiers, c.OrderPrefix, c.Multiplier, c.FixedBonus, c.CapPerUser, c.Active,
+		tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("create campaign error")
+		return campaigns.Campaign{}, err
+	}
+	return res, nil
+}
+
+// UpdateCampaign replaces the rules of the campaign, bonuses already credited stay
+func UpdateCampaign(ctx context.Context, id int, c campaigns.Campaign) (campaigns.Campaign, error) {
+	if err := c.Validate(); err != nil {
+		return campaigns.Campaign{}, fmt.Errorf("%w: %v", ErrBadCampaign, err)
+	}
+
+	var res campaigns.Campaign
+	err := db.GetContext(ctx, &res, `
+UPDATE campaigns SET name = $1, starts_at = $2, ends_at = $3, first_order = $4, tiers = $5, 
+	order_prefix = $6, multiplier = $7, fixed_bonus = $8, cap_per_user = $9, active = $10 
+WHERE campaign_id = $11 AND tenant_id = $12 
+RETURNING `+campaignColumns+`;`,
+		c.Name, c.StartsAt, c.EndsAt, c.FirstOrder, c.Tiers, c.OrderPrefix, c.Multiplier, c.FixedBonus, c.CapPerUser, c.Active, id,
+		tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return campaigns.Campaign{}, ErrNotFound
+		}
+		log.Err(err).Msg("update campaign error")
+		return campaigns.Campaign{}, err
+	}
+	return res, nil
+}
+
+// StopCampaign deactivates the campaign, it is kept for the bonuses it gave
+func StopCampaign(ctx context.Context, id int) error {
+	res, err := db.ExecContext(ctx, `UPDATE campaigns SET active = false WHERE campaign_id = $1 AND tenant_id = $2`, id, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("stop campaign error")
+		return err
+	}
+	return mustAffect(res)
+}
+
+// GetCampaign
+func GetCampaign(ctx context.Context, id int) (campaigns.Campaign, error) {
+	var res campaigns.Campaign
+	err := db.GetContext(ctx, &res, `SELECT `+campaignColumns+` FROM campaigns WHERE campaign_id = $1 AND tenant_id = $2`,
+		id, tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return campaigns.Campaign{}, ErrNotFound
+		}
+		log.Err(err).Msg("get campaign error")
+		return campaigns.Campaign{}, err
+	}
+	return res, nil
+}
+
+// GetCampaigns
+func GetCampaigns(ctx context.Context) ([]campaigns.Campaign, error) {
+	res := []campaigns.Campaign{}
+	err := db.SelectContext(ctx, &res, `SELECT `+campaignColumns+` FROM campaigns WHERE tenant_id = $1 ORDER BY starts_at DESC`,
+		tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get campaigns error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// PreviewCampaign runs the campaign over the orders processed in its window without crediting anything,
+// tiers are the current ones of the users
+func PreviewCampaign(ctx context.Context, c campaigns.Campaign) (CampaignPreview, error) {
+	if err := c.Validate(); err != nil {
+		return CampaignPreview{}, fmt.Errorf("%w: %v", ErrBadCampaign, err)
+	}
+	c.Active = true
+
+	rows, err := db.QueryxContext(ctx, `
+SELECT o.username, o.order_id, o.accrual, o.uploaded_at, u.tier, o.first_order FROM (
+	SELECT username, order_id, accrual, uploaded_at, 
+		row_number() OVER (PARTITION BY username ORDER BY uploaded_at, order_id) = 1 AS first_order 
+	FROM orders WHERE status = 'PROCESSED' AND tenant_id = $3 
+) o JOIN users u ON u.username = o.username 
+WHERE o.uploaded_at >= $1::timestamptz AND o.uploaded_at < $2::timestamptz 
+ORDER BY o.uploaded_at, o.order_id;`, c.StartsAt, c.EndsAt, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("campaign preview error")
+		return CampaignPreview{}, err
+	}
+	defer rows.Close()
+
+	res := CampaignPreview{Bonuses: []PreviewedBonus{}, Draft: c}
+	awarded := map[string]float32{}
+	for rows.Next() {
+		var login string
+		var o campaigns.Order
+		if err = rows.Scan(&login, &o.Number, &o.Accrual, &o.ProcessedAt, &o.Tier, &o.FirstOrder); err != nil {
+			log.Err(err).Msg("campaign preview scan error")
+			return CampaignPreview{}, err
+		}
+		if !c.Eligible(o) {
+			continue
+		}
+		amount := c.Reward(o, awarded[login])
+		if amount <= 0 {
+			continue
+		}
+
+		if _, ok := awarded[login]; !ok {
+			res.Users++
+		}
+		awarded[login] += amount
+		res.Orders++
+		res.Total += amount
+		if len(res.Bonuses) < previewLimit {
+			res.Bonuses = append(res.Bonuses, PreviewedBonus{Login: login, Order: o.Number, Amount: amount})
+		}
+	}
+	return res, rows.Err()
+}
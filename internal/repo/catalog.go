@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/fraud"
+	"github.com/lekan/gophermart/internal/luhn"
+	"github.com/lekan/gophermart/internal/policy"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// catalogOrderBase is the prefix of the order numbers made for redemptions
+const catalogOrderBase = 9900000000
+
+// ErrBadItem
+var ErrBadItem = errors.New("wrong catalog item")
+
+// ErrReservedOrder is returned for a user withdrawal to an order number of the redemptions
+var ErrReservedOrder = &policy.Violation{Code: "order_number_reserved", Message: "the order number is reserved for catalog redemptions"}
+
+// CatalogItem, empty availability bounds are open
+type CatalogItem struct {
+	ID            int        `json:"id" db:"item_id"`
+	Title         string     `json:"title" db:"title"`
+	Description   string     `json:"description,omitempty" db:"description"`
+	Price         float32    `json:"price" db:"price"`
+	Stock         int        `json:"stock" db:"stock"`
+	AvailableFrom *time.Time `json:"available_from,omitempty" db:"available_from"`
+	AvailableTo   *time.Time `json:"available_to,omitempty" db:"available_to"`
+	Active        bool       `json:"active" db:"active"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	TenantID      string     `json:"-" db:"tenant_id"`
+}
+
+// available
+func (i CatalogItem) available(now time.Time) bool {
+	return i.Active &&
+		(i.AvailableFrom == nil || !now.Before(*i.AvailableFrom)) &&
+		(i.AvailableTo == nil || now.Before(*i.AvailableTo))
+}
+
+// validate
+func (i CatalogItem) validate() error {
+	switch {
+	case i.Title == "":
+		return fmt.Errorf("%w: title is required", ErrBadItem)
+	case i.Price <= 0:
+		return fmt.Errorf("%w: price must be positive", ErrBadItem)
+	case i.Stock < 0:
+		return fmt.Errorf("%w: stock can not be negative", ErrBadItem)
+	case i.AvailableFrom != nil && i.AvailableTo != nil && !i.AvailableFrom.Before(*i.AvailableTo):
+		return fmt.Errorf("%w: wrong availability window", ErrBadItem)
+	}
+	return nil
+}
+
+// RedeemRequest
+type RedeemRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+// CatalogRedemption
+type CatalogRedemption struct {
+	ID        int       `json:"id" db:"redemption_id"`
+	ItemID    int       `json:"item_id" db:"item_id"`
+	Title     string    `json:"title" db:"title"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	Price     float32   `json:"price" db:"price"`
+	Total     float32   `json:"total" db:"total"`
+	Order     string    `json:"order" db:"order_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// redemptionOrder makes a Luhn valid order number for the withdrawal of a redemption
+func redemptionOrder(id int) string {
+	base := catalogOrderBase + id
+	return strconv.Itoa(base*10 + luhn.CalculateLuhn(base))
+}
+
+// isRedemptionOrder tells the numbers redemptionOrder makes, users can not withdraw to them
+func isRedemptionOrder(order string) bool {
+	number, err := strconv.Atoi(order)
+	if err != nil {
+		return false
+	}
+	base := number / 10
+	return len(order) == len(strconv.Itoa(catalogOrderBase))+1 && base >= catalogOrderBase
+}
+
+// RedeemItem pays for the item with points: the withdrawal, the redemption and the stock change in one transaction
+func RedeemItem(ctx context.Context, login string, itemID int, req RedeemRequest) (CatalogRedemption, int, error) {
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.Quantity < 0 {
+		return CatalogRedemption{}, http.StatusBadRequest, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	action, err := restriction(ctx, tx, login, fraud.OpRedeem)
+	if err != nil {
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+	if action != fraud.Allow {
+		log.Info().Msgf("flagged user %s can not redeem items", login)
+		return CatalogRedemption{}, http.StatusForbidden, nil
+	}
+
+	var item CatalogItem
+	err = tx.GetContext(ctx, &item, `SELECT * FROM catalog_items WHERE item_id = $1 AND tenant_id = $2 FOR UPDATE`, itemID, tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CatalogRedemption{}, http.StatusNotFound, nil
+		}
+		log.Err(err).Msg("lock catalog item error")
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+	if !item.available(time.Now()) {
+		return CatalogRedemption{}, http.StatusGone, nil
+	}
+	if item.Stock < req.Quantity {
+		log.Info().Msgf("item %d is out of stock", itemID)
+		return CatalogRedemption{}, http.StatusConflict, nil
+	}
+
+	total := item.Price * float32(req.Quantity)
+	var red CatalogRedemption
+	err = tx.GetContext(ctx, &red, `
+INSERT INTO catalog_redemptions(item_id, username, quantity, price, total) 
+VALUES ($1, $2, $3, $4, $5) 
+RETURNING redemption_id, item_id, quantity, price, total, created_at;`, itemID, login, req.Quantity, item.Price, total)
+	if err != nil {
+		log.Err(err).Msg("redemption insert error")
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+	red.Title = item.Title
+	red.Order = redemptionOrder(red.ID)
+
+	if _, err = tx.ExecContext(ctx, `UPDATE catalog_redemptions SET order_id = $1 WHERE redemption_id = $2`, red.Order, red.ID); err != nil {
+		log.Err(err).Msg("redemption order update error")
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if statusCode, err := withdrawTx(ctx, tx, login, red.Order, total); statusCode != http.StatusOK {
+		if errors.Is(err, ErrOrderPaid) {
+			// номер занят выводом, сделанным до закрытия диапазона для пользователей, это не ошибка клиента
+			return CatalogRedemption{}, http.StatusInternalServerError, fmt.Errorf("redemption order %s is taken: %w", red.Order, err)
+		}
+		return CatalogRedemption{}, statusCode, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE catalog_items SET stock = stock - $1 WHERE item_id = $2`, req.Quantity, itemID); err != nil {
+		log.Err(err).Msg("stock update error")
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return CatalogRedemption{}, http.StatusInternalServerError, err
+	}
+	return red, http.StatusOK, nil
+}
+
+// GetRedemptions
+func GetRedemptions(ctx context.Context, login string) ([]CatalogRedemption, error) {
+	res := []CatalogRedemption{}
+	err := db.SelectContext(ctx, &res, `
+SELECT r.redemption_id, r.item_id, i.title, r.quantity, r.price, r.total, r.order_id, r.created_at 
+FROM catalog_redemptions r JOIN catalog_items i ON i.item_id = r.item_id 
+WHERE r.username = $1 
+ORDER BY r.created_at DESC;`, login)
+	if err != nil {
+		log.Err(err).Msg("get redemptions error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetCatalog, with onlyAvailable the items users can redeem now
+func GetCatalog(ctx context.Context, onlyAvailable bool) ([]CatalogItem, error) {
+	res := []CatalogItem{}
+	err := db.SelectContext(ctx, &res, `
+SELECT * FROM catalog_items 
+WHERE tenant_id = $2 AND (NOT $1 OR (active AND stock > 0 
+	AND (available_from IS NULL OR available_from <= now()) 
+	AND (available_to IS NULL OR available_to > now()))) 
+ORDER BY price, item_id;`, onlyAvailable, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get catalog error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// CreateItem
+func CreateItem(ctx context.Context, item CatalogItem) (CatalogItem, error) {
+	if err := item.validate(); err != nil {
+		return CatalogItem{}, err
+	}
+
+	var res CatalogItem
+	err := db.GetContext(ctx, &res, `
+INSERT INTO catalog_items(title, description, price, stock, available_from, available_to, active, tenant_id) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+RETURNING *;`, item.Title, item.Description, item.Price, item.Stock, item.AvailableFrom, item.AvailableTo, item.Active,
+		tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("create item error")
+		return CatalogItem{}, err
+	}
+	return res, nil
+}
+
+// UpdateItem
+func UpdateItem(ctx context.Context, id int, item CatalogItem) (CatalogItem, error) {
+	if err := item.validate(); err != nil {
+		return CatalogItem{}, err
+	}
+
+	var res CatalogItem
+	err := db.GetContext(ctx, &res, `
+UPDATE catalog_items SET title = $1, description = $2, price = $3, stock = $4, 
+	available_from = $5, available_to = $6, active = $7 
+WHERE item_id = $8 AND tenant_id = $9 
+RETURNING *;`, item.Title, item.Description, item.Price, item.Stock, item.AvailableFrom, item.AvailableTo, item.Active, id,
+		tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CatalogItem{}, ErrNotFound
+		}
+		log.Err(err).Msg("update item error")
+		return CatalogItem{}, err
+	}
+	return res, nil
+}
+
+// GetItem
+func GetItem(ctx context.Context, id int) (CatalogItem, error) {
+	var res CatalogItem
+	if err := db.GetContext(ctx, &res, `SELECT * FROM catalog_items WHERE item_id = $1 AND tenant_id = $2`, id, tenantID(ctx)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CatalogItem{}, ErrNotFound
+		}
+		log.Err(err).Msg("get item error")
+		return CatalogItem{}, err
+	}
+	return res, nil
+}
+
+// DeactivateItem hides the item, it stays for the redemption history
+func DeactivateItem(ctx context.Context, id int) error {
+	res, err := db.ExecContext(ctx, `UPDATE catalog_items SET active = false WHERE item_id = $1 AND tenant_id = $2`, id, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("deactivate item error")
+		return err
+	}
+	return mustAffect(res)
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedeemItem(t *testing.T) {
+	ctx := testContext(t)
+
+	item, err := CreateItem(ctx, CatalogItem{Title: "test " + testSuffix(), Price: 3, Stock: 2, Active: true})
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+	rich := testUser(t, ctx)
+	testCredit(t, ctx, rich, 10)
+	poor := testUser(t, ctx)
+	testCredit(t, ctx, poor, 2)
+
+	tests := []struct {
+		name           string
+		login          string
+		quantity       int
+		wantStatusCode int
+		wantCurrent    float32
+		wantWithdrawn  float32
+		wantStock      int
+	}{
+		{
+			name:           "success test #1",
+			login:          rich,
+			quantity:       1,
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    7,
+			wantWithdrawn:  3,
+			wantStock:      1,
+		},
+		{
+			name:           "insufficient funds",
+			login:          poor,
+			quantity:       1,
+			wantStatusCode: http.StatusPaymentRequired,
+			wantCurrent:    2,
+			wantStock:      1,
+		},
+		{
+			name:           "out of stock",
+			login:          rich,
+			quantity:       2,
+			wantStatusCode: http.StatusConflict,
+			wantCurrent:    7,
+			wantWithdrawn:  3,
+			wantStock:      1,
+		},
+		{
+			name:           "success test #2",
+			login:          rich,
+			quantity:       1,
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    4,
+			wantWithdrawn:  6,
+			wantStock:      0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, statusCode, err := RedeemItem(ctx, tt.login, item.ID, RedeemRequest{Quantity: tt.quantity})
+			if statusCode != tt.wantStatusCode {
+				t.Fatalf("RedeemItem() = %d, %v, want %d", statusCode, err, tt.wantStatusCode)
+			}
+			wantBalance(t, ctx, tt.login, tt.wantCurrent, tt.wantWithdrawn)
+
+			got, err := GetItem(ctx, item.ID)
+			if err != nil {
+				t.Fatalf("GetItem() error = %v", err)
+			}
+			if got.Stock != tt.wantStock {
+				t.Fatalf("stock = %d, want %d", got.Stock, tt.wantStock)
+			}
+		})
+	}
+}
+
+func TestIsRedemptionOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		want  bool
+	}{
+		{name: "success test #1", order: redemptionOrder(1), want: true},
+		{name: "success test #2", order: redemptionOrder(99999999), want: true},
+		{name: "user order", order: "12345678903", want: false},
+		{name: "longer number", order: "990000000012", want: false},
+		{name: "leading zero", order: "09900000001", want: false},
+		{name: "not a number", order: "99000000abc", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRedemptionOrder(tt.order); got != tt.want {
+				t.Errorf("isRedemptionOrder(%s) = %v, want %v", tt.order, got, tt.want)
+			}
+		})
+	}
+}
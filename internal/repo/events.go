@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/config"
+	"time"
+)
+
+// EventsChannel is the Postgres NOTIFY channel for user events
+const EventsChannel = "user_events"
+
+const (
+	EventOrder   = "order"
+	EventBalance = "balance"
+)
+
+// Event
+type Event struct {
+	ID        int64           `json:"event_id" db:"event_id"`
+	Login     string          `json:"username" db:"username"`
+	Type      string          `json:"event_type" db:"event_type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"-" db:"created_at"`
+}
+
+// publishEvent stores the event and notifies every listening instance,
+// inside a transaction the notification is sent on commit
+func publishEvent(ctx context.Context, q sqlx.ExecerContext, login string, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, `
+WITH e AS (
+	INSERT INTO events(username, event_type, payload) 
+	VALUES ($1, $2, $3) 
+	RETURNING event_id, username, event_type, payload
+)
+SELECT pg_notify($4, row_to_json(e)::text) FROM e;`, login, eventType, string(data), EventsChannel)
+	if err != nil {
+		log.Err(err).Msg("publish event error")
+		return err
+	}
+
+	return nil
+}
+
+// publishBalance sends the current balance of the user as an event
+func publishBalance(ctx context.Context, q sqlx.ExecerContext, login string) error {
+	_, err := q.ExecContext(ctx, `
+WITH e AS (
+	INSERT INTO events(username, event_type, payload) 
+	SELECT username, $2, json_build_object('current', balance, 'withdrawn', withdrawn) 
+	FROM users WHERE username = $1 
+	RETURNING event_id, username, event_type, payload
+)
+SELECT pg_notify($3, row_to_json(e)::text) FROM e;`, login, EventBalance, EventsChannel)
+	if err != nil {
+		log.Err(err).Msg("publish balance error")
+		return err
+	}
+
+	return nil
+}
+
+// eventsReplayLimit bounds the replay of a reconnecting client, the latest events are the ones sent
+const eventsReplayLimit = 500
+
+// GetEventsSince returns the events of the user newer than lastID, at most the latest eventsReplayLimit of them
+func GetEventsSince(ctx context.Context, login string, lastID int64) ([]Event, error) {
+	events := []Event{}
+	err := db.SelectContext(ctx, &events, `
+SELECT * FROM (
+	SELECT event_id, username, event_type, payload, created_at 
+	FROM events 
+	WHERE username = $1 AND event_id > $2 
+	ORDER BY event_id DESC 
+	LIMIT $3
+) e 
+ORDER BY event_id;`, login, lastID, eventsReplayLimit)
+	if err != nil {
+		log.Err(err).Msg("get events error")
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetLastEventID is where a new stream of the user starts, 0 without events
+func GetLastEventID(ctx context.Context, login string) (int64, error) {
+	var id int64
+	err := db.GetContext(ctx, &id, `SELECT COALESCE(MAX(event_id), 0) FROM events WHERE username = $1`, login)
+	if err != nil {
+		log.Err(err).Msg("last event error")
+	}
+	return id, err
+}
+
+// PurgeEvents deletes the events older than the retention, a client that was away longer gets no replay
+func PurgeEvents(ctx context.Context) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM events WHERE created_at < $1::timestamptz`, time.Now().Add(-config.Get().EventsRetention))
+	if err != nil {
+		log.Err(err).Msg("purge events error")
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Info().Msgf("%d events purged", n)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/fraud"
+	"github.com/lekan/gophermart/internal/policy"
+	"net/http"
+	"time"
+)
+
+const (
+	FlagOpen    = "OPEN"
+	FlagCleared = "CLEARED"
+
+	// HoldReview holds a withdrawal of a flagged user until an admin decides
+	HoldReview   = "REVIEW"
+	HoldRejected = "REJECTED"
+)
+
+// FraudFlag
+type FraudFlag struct {
+	ID        int        `json:"id" db:"flag_id"`
+	Login     string     `json:"login" db:"username"`
+	Rule      string     `json:"rule" db:"rule"`
+	Reason    string     `json:"reason" db:"reason"`
+	Status    string     `json:"status" db:"status"`
+	Note      string     `json:"note,omitempty" db:"note"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ClearedAt *time.Time `json:"cleared_at,omitempty" db:"cleared_at"`
+}
+
+type clientIPKey struct{}
+
+// WithClientIP keeps the address of the client for the velocity rules
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// recordAttempt stores the outcome of an order upload, a failure only costs the statistics
+func recordAttempt(ctx context.Context, login string, orderID string, statusCode int) {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO order_attempts(username, ip, order_id, status_code) 
+VALUES ($1, $2, $3, $4);`, login, clientIP(ctx), orderID, statusCode)
+	if err != nil {
+		log.Err(err).Msg("order attempt insert error")
+	}
+}
+
+// attemptStats counts the uploads of the subject of the rule within its window
+func attemptStats(ctx context.Context, r fraud.Rule, login string, ip string) (fraud.Stats, error) {
+	since := time.Now().Add(-r.Window)
+
+	var s fraud.Stats
+	var err error
+	switch r.Subject {
+	case fraud.SubjectIP:
+		if ip == "" {
+			return s, nil
+		}
+		err = db.GetContext(ctx, &s, `
+SELECT COUNT(*) AS attempts, 
+	COUNT(*) FILTER (WHERE status_code IN (409, 422)) AS rejected, 
+	(SELECT COUNT(*) FROM orders o 
+		JOIN order_attempts a ON a.order_id = o.order_id AND a.username = o.username AND a.status_code = 202 
+		WHERE a.ip = $1 AND o.tenant_id = $3 AND o.status = 'INVALID' AND o.uploaded_at > $2::timestamptz) AS invalid 
+FROM order_attempts 
+WHERE ip = $1 AND created_at > $2::timestamptz AND username IN (SELECT username FROM users WHERE tenant_id = $3);`, ip, since, tenantID(ctx))
+	default:
+		err = db.GetContext(ctx, &s, `
+SELECT COUNT(*) AS attempts, 
+	COUNT(*) FILTER (WHERE status_code IN (409, 422)) AS rejected, 
+	(SELECT COUNT(*) FROM orders 
+		WHERE username = $1 AND status = 'INVALID' AND uploaded_at > $2::timestamptz) AS invalid 
+FROM order_attempts 
+WHERE username = $1 AND created_at > $2::timestamptz;`, login, since)
+	}
+	if err != nil {
+		log.Err(err).Msg("attempt stats error")
+	}
+	return s, err
+}
+
+// CheckVelocity applies the fraud rules in force to the user and the address,
+// broken flag rules are recorded, the caller decides what to do with throttling
+func CheckVelocity(ctx context.Context, login string, ip string) (fraud.Decision, error) {
+	d, err := fraud.Evaluate(policy.Get().Fraud, func(r fraud.Rule) (fraud.Stats, error) {
+		return attemptStats(ctx, r, login, ip)
+	})
+	if err != nil {
+		return fraud.Decision{}, err
+	}
+
+	for _, hit := range d.Flags {
+		res, err := db.ExecContext(ctx, `
+INSERT INTO fraud_flags(username, rule, reason) 
+VALUES ($1, $2, $3) 
+ON CONFLICT (username, rule) WHERE status = 'OPEN' DO NOTHING;`, login, hit.Rule, hit.Reason)
+		if err != nil {
+			log.Err(err).Msg("fraud flag insert error")
+			return fraud.Decision{}, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			log.Warn().Msgf("%s is flagged by %s: %s", login, hit.Rule, hit.Reason)
+		}
+	}
+	return d, nil
+}
+
+// ErrUnderReview is returned for the operations a flagged user can not make
+var ErrUnderReview = &policy.Violation{Code: "account_under_review", Message: "the account is under review"}
+
+// isFlagged
+func isFlagged(ctx context.Context, q sqlx.QueryerContext, login string) (bool, error) {
+	var flagged bool
+	err := sqlx.GetContext(ctx, q, &flagged, `
+SELECT EXISTS(SELECT 1 FROM fraud_flags WHERE username = $1 AND status = 'OPEN')`, login)
+	if err != nil {
+		log.Err(err).Msg("fraud flag lookup error")
+	}
+	return flagged, err
+}
+
+// restriction tells what the fraud flags of the user allow for the operation
+func restriction(ctx context.Context, q sqlx.QueryerContext, login string, op string) (string, error) {
+	flagged, err := isFlagged(ctx, q, login)
+	if err != nil {
+		return "", err
+	}
+	return fraud.Restrict(op, flagged), nil
+}
+
+// holdForReview keeps the points of a withdrawal by a flagged user aside until an admin approves it,
+// review holds do not expire. The caller commits the transaction.
+func holdForReview(ctx context.Context, tx *sqlx.Tx, login string, order string, withdraw float32) (Hold, int, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, login); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	if statusCode, err := checkWithdrawalPolicy(ctx, tx, login, order, withdraw); statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	var pending bool
+	if err := tx.GetContext(ctx, &pending, `
+SELECT EXISTS(SELECT 1 FROM holds WHERE order_id = $1 AND status = 'REVIEW' 
+	AND username IN (SELECT username FROM users WHERE tenant_id = $2))`, order, tenantID(ctx)); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	if pending {
+		return Hold{}, http.StatusConflict, ErrOrderPaid
+	}
+
+	hold, statusCode, err := insertHold(ctx, tx, login, order, withdraw, HoldReview, time.Now())
+	if statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+	log.Info().Msgf("withdrawal of %s is held for review as hold #%d", login, hold.ID)
+	return hold, http.StatusAccepted, nil
+}
+
+// GetFraudFlags, an empty status gives all flags
+func GetFraudFlags(ctx context.Context, status string) ([]FraudFlag, error) {
+	res := []FraudFlag{}
+	err := db.SelectContext(ctx, &res, `
+SELECT * FROM fraud_flags 
+WHERE ($1 = '' OR status = $1) AND username IN (SELECT username FROM users WHERE tenant_id = $2) 
+ORDER BY created_at DESC 
+LIMIT 1000;`, status, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get fraud flags error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// ClearFraudFlag
+func ClearFraudFlag(ctx context.Context, id int, note string) (FraudFlag, error) {
+	var res FraudFlag
+	err := db.GetContext(ctx, &res, `
+UPDATE fraud_flags SET status = 'CLEARED', note = $2, cleared_at = now() 
+WHERE flag_id = $1 AND status = 'OPEN' AND username IN (SELECT username FROM users WHERE tenant_id = $3) 
+RETURNING *;`, id, note, tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FraudFlag{}, ErrNotFound
+		}
+		log.Err(err).Msg("clear fraud flag error")
+		return FraudFlag{}, err
+	}
+	return res, nil
+}
+
+// GetReviewHolds is the queue of withdrawals waiting for an admin
+func GetReviewHolds(ctx context.Context) ([]Hold, error) {
+	res := []Hold{}
+	err := db.SelectContext(ctx, &res, `
+SELECT * FROM holds 
+WHERE status = 'REVIEW' AND username IN (SELECT username FROM users WHERE tenant_id = $1) 
+ORDER BY created_at;`, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get review holds error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// lockReviewHold
+func lockReviewHold(ctx context.Context, tx *sqlx.Tx, id int) (Hold, error) {
+	var hold Hold
+	err := tx.GetContext(ctx, &hold, `
+SELECT * FROM holds 
+WHERE hold_id = $1 AND username IN (SELECT username FROM users WHERE tenant_id = $2) 
+FOR UPDATE;`, id, tenantID(ctx))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrNotFound
+		}
+		log.Err(err).Msg("lock hold error")
+		return Hold{}, err
+	}
+	if hold.Status != HoldReview {
+		return Hold{}, ErrHoldClosed
+	}
+	return hold, nil
+}
+
+// ApproveReview makes the withdrawal the user asked for
+func ApproveReview(ctx context.Context, id int) (Hold, int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	hold, err := lockReviewHold(ctx, tx, id)
+	if err != nil {
+		return Hold{}, holdStatusCode(err), err
+	}
+
+	if hold, err = releaseHold(ctx, tx, hold, HoldCaptured, hold.Amount); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	if statusCode, err := withdrawTx(ctx, tx, hold.Login, hold.Order, hold.Amount); statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusOK, nil
+}
+
+// RejectReview returns the points to the user
+func RejectReview(ctx context.Context, id int) (Hold, int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	hold, err := lockReviewHold(ctx, tx, id)
+	if err != nil {
+		return Hold{}, holdStatusCode(err), err
+	}
+
+	if hold, err = releaseHold(ctx, tx, hold, HoldRejected, 0); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusOK, nil
+}
@@ -0,0 +1,330 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/fraud"
+	"net/http"
+	"time"
+)
+
+const (
+	HoldActive   = "ACTIVE"
+	HoldCaptured = "CAPTURED"
+	HoldVoided   = "VOIDED"
+	HoldExpired  = "EXPIRED"
+
+	LedgerHold        = "hold"
+	LedgerHoldRelease = "hold_release"
+)
+
+// Hold reserves points for a pending payment
+type Hold struct {
+	ID        int        `json:"id" db:"hold_id"`
+	Login     string     `json:"-" db:"username"`
+	Order     string     `json:"order" db:"order_id"`
+	Amount    float32    `json:"amount" db:"amount"`
+	Status    string     `json:"status" db:"status"`
+	Captured  float32    `json:"captured,omitempty" db:"captured"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty" db:"closed_at"`
+}
+
+// HoldRequest
+type HoldRequest struct {
+	Order string  `json:"order"`
+	Sum   float32 `json:"sum"`
+	TTL   string  `json:"ttl,omitempty"`
+}
+
+// CaptureRequest, without Sum the whole hold is captured
+type CaptureRequest struct {
+	Sum *float32 `json:"sum,omitempty"`
+}
+
+// CreateHold takes the points off the available balance without a withdrawal,
+// the checks and status codes are the ones of Withdraw. A flagged user can not reserve points.
+func CreateHold(ctx context.Context, login string, req *HoldRequest) (Hold, int, error) {
+	if statusCode, err := checkWithdraw(ctx, req.Order, req.Sum); statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	c := config.Get()
+	ttl := c.HoldTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 || ttl > c.HoldMaxTTL {
+			log.Info().Msgf("wrong hold ttl %s", req.TTL)
+			return Hold{}, http.StatusBadRequest, nil
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, login); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	action, err := restriction(ctx, tx, login, fraud.OpHold)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	if action != fraud.Allow {
+		log.Info().Msgf("flagged user %s can not hold points", login)
+		return Hold{}, http.StatusForbidden, ErrUnderReview
+	}
+
+	if statusCode, err := checkWithdrawalPolicy(ctx, tx, login, req.Order, req.Sum); statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	hold, statusCode, err := insertHold(ctx, tx, login, req.Order, req.Sum, HoldActive, time.Now().Add(ttl))
+	if statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusCreated, nil
+}
+
+// insertHold moves the sum from the balance to the new hold and remembers the lots it was taken from
+func insertHold(ctx context.Context, tx *sqlx.Tx, login string, order string, sum float32, status string, expiresAt time.Time) (Hold, int, error) {
+	var hold Hold
+	err := tx.GetContext(ctx, &hold, `
+INSERT INTO holds(username, order_id, amount, status, expires_at) 
+VALUES ($1, $2, $3, $4, $5) 
+RETURNING *;`, login, order, sum, status, expiresAt)
+	if err != nil {
+		log.Err(err).Msg("create hold error")
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	takes, err := debitEntry(ctx, tx, login, LedgerHold, sum, order, fmt.Sprintf("hold #%d", hold.ID))
+	if err != nil {
+		if errors.Is(err, ErrInsufficientFunds) {
+			return Hold{}, http.StatusPaymentRequired, nil
+		}
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	for _, v := range takes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO hold_lots(hold_id, lot_id, amount) VALUES ($1, $2, $3)`, hold.ID, v.LotID, v.Amount)
+		if err != nil {
+			log.Err(err).Msg("hold lot error")
+			return Hold{}, http.StatusInternalServerError, err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET held = held + $1 WHERE username = $2`, sum, login); err != nil {
+		log.Err(err).Msg("held update error")
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusOK, nil
+}
+
+// CaptureHold turns the hold into a withdrawal for its order, the rest of the hold goes back to the balance.
+// For a flagged user the hold is voided and the captured sum waits for review, the answer is 202.
+func CaptureHold(ctx context.Context, login string, id int, req *CaptureRequest) (Hold, int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	hold, err := lockHold(ctx, tx, login, id)
+	if err != nil {
+		return Hold{}, holdStatusCode(err), err
+	}
+
+	sum := hold.Amount
+	if req.Sum != nil {
+		sum = *req.Sum
+	}
+	if sum <= 0 || sum > hold.Amount {
+		log.Info().Msgf("wrong capture sum %v of hold %d", sum, id)
+		return Hold{}, http.StatusBadRequest, nil
+	}
+
+	action, err := restriction(ctx, tx, login, fraud.OpCapture)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	if action == fraud.Review {
+		// захват помеченного пользователя уходит на проверку как обычный вывод
+		if _, err = releaseHold(ctx, tx, hold, HoldVoided, 0); err != nil {
+			return Hold{}, http.StatusInternalServerError, err
+		}
+		review, statusCode, err := holdForReview(ctx, tx, login, hold.Order, sum)
+		if statusCode != http.StatusAccepted {
+			return Hold{}, statusCode, err
+		}
+		if err = tx.Commit(); err != nil {
+			return Hold{}, http.StatusInternalServerError, err
+		}
+		return review, statusCode, nil
+	}
+
+	if hold, err = releaseHold(ctx, tx, hold, HoldCaptured, sum); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	if statusCode, err := withdrawTx(ctx, tx, login, hold.Order, sum); statusCode != http.StatusOK {
+		return Hold{}, statusCode, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusOK, nil
+}
+
+// VoidHold returns the held points to the balance
+func VoidHold(ctx context.Context, login string, id int) (Hold, int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	hold, err := lockHold(ctx, tx, login, id)
+	if err != nil {
+		return Hold{}, holdStatusCode(err), err
+	}
+
+	if hold, err = releaseHold(ctx, tx, hold, HoldVoided, 0); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Hold{}, http.StatusInternalServerError, err
+	}
+	return hold, http.StatusOK, nil
+}
+
+// ErrHoldClosed
+var ErrHoldClosed = errors.New("hold is not active")
+
+// lockHold
+func lockHold(ctx context.Context, tx *sqlx.Tx, login string, id int) (Hold, error) {
+	var hold Hold
+	err := tx.GetContext(ctx, &hold, `SELECT * FROM holds WHERE hold_id = $1 AND username = $2 FOR UPDATE`, id, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrNotFound
+		}
+		log.Err(err).Msg("lock hold error")
+		return Hold{}, err
+	}
+	if hold.Status != HoldActive {
+		return Hold{}, ErrHoldClosed
+	}
+	return hold, nil
+}
+
+// holdStatusCode
+func holdStatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrHoldClosed):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// releaseHold closes the hold and credits the held points back into the lots they came from
+func releaseHold(ctx context.Context, tx *sqlx.Tx, hold Hold, status string, captured float32) (Hold, error) {
+	var closed Hold
+	err := tx.GetContext(ctx, &closed, `
+UPDATE holds SET status = $2, captured = $3, closed_at = now() 
+WHERE hold_id = $1 
+RETURNING *;`, hold.ID, status, captured)
+	if err != nil {
+		log.Err(err).Msg("close hold error")
+		return Hold{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET held = held - $1 WHERE username = $2`, hold.Amount, hold.Login); err != nil {
+		log.Err(err).Msg("held update error")
+		return Hold{}, err
+	}
+
+	takes := []lotTake{}
+	if err = tx.SelectContext(ctx, &takes, `SELECT lot_id, amount FROM hold_lots WHERE hold_id = $1`, hold.ID); err != nil {
+		log.Err(err).Msg("hold lots error")
+		return Hold{}, err
+	}
+
+	err = restoreEntry(ctx, tx, hold.Login, LedgerHoldRelease, hold.Amount, hold.Order, fmt.Sprintf("hold #%d", hold.ID), takes)
+	if err != nil {
+		return Hold{}, err
+	}
+	return closed, nil
+}
+
+// GetHolds
+func GetHolds(ctx context.Context, login string) ([]Hold, error) {
+	res := []Hold{}
+	err := db.SelectContext(ctx, &res, `
+SELECT * FROM holds 
+WHERE username = $1 
+ORDER BY status <> 'ACTIVE', created_at DESC 
+LIMIT 100;`, login)
+	if err != nil {
+		log.Err(err).Msg("get holds error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// ExpireHolds voids the holds that were neither captured nor voided in time
+func ExpireHolds(ctx context.Context) error {
+	ids := []int{}
+	err := db.SelectContext(ctx, &ids, `SELECT hold_id FROM holds WHERE status = 'ACTIVE' AND expires_at <= now() LIMIT 1000`)
+	if err != nil {
+		log.Err(err).Msg("expired holds error")
+		return err
+	}
+
+	for _, id := range ids {
+		if err = expireHold(ctx, id); err != nil && !errors.Is(err, ErrHoldClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireHold
+func expireHold(ctx context.Context, id int) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var login string
+	if err = tx.GetContext(ctx, &login, `SELECT username FROM holds WHERE hold_id = $1`, id); err != nil {
+		return err
+	}
+
+	hold, err := lockHold(ctx, tx, login, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = releaseHold(ctx, tx, hold, HoldExpired, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHolds(t *testing.T) {
+	ctx := testContext(t)
+
+	tests := []struct {
+		name          string
+		hold          float32
+		capture       *float32
+		wantCurrent   float32
+		wantWithdrawn float32
+		wantRemaining []float32
+	}{
+		{
+			name:          "void",
+			hold:          6,
+			wantCurrent:   10,
+			wantRemaining: []float32{10},
+		},
+		{
+			name:          "capture a part",
+			hold:          6,
+			capture:       sumOf(4),
+			wantCurrent:   6,
+			wantWithdrawn: 4,
+			wantRemaining: []float32{6},
+		},
+		{
+			name:          "capture all",
+			hold:          6,
+			capture:       sumOf(6),
+			wantCurrent:   4,
+			wantWithdrawn: 6,
+			wantRemaining: []float32{4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := testUser(t, ctx)
+			testCredit(t, ctx, login, 10)
+
+			hold, statusCode, err := CreateHold(ctx, login, &HoldRequest{Order: testOrder(), Sum: tt.hold})
+			if statusCode != http.StatusCreated {
+				t.Fatalf("CreateHold() = %d, %v", statusCode, err)
+			}
+			if b := testBalance(t, ctx, login); b.Current != 10-tt.hold || b.Held != tt.hold {
+				t.Fatalf("balance = %v held %v, want %v held %v", b.Current, b.Held, 10-tt.hold, tt.hold)
+			}
+
+			if tt.capture == nil {
+				_, statusCode, err = VoidHold(ctx, login, hold.ID)
+			} else {
+				_, statusCode, err = CaptureHold(ctx, login, hold.ID, &CaptureRequest{Sum: tt.capture})
+			}
+			if statusCode != http.StatusOK {
+				t.Fatalf("closing hold = %d, %v", statusCode, err)
+			}
+
+			wantBalance(t, ctx, login, tt.wantCurrent, tt.wantWithdrawn)
+			wantRemaining(t, ctx, login, tt.wantRemaining)
+			if b := testBalance(t, ctx, login); b.Held != 0 {
+				t.Fatalf("held = %v, want 0", b.Held)
+			}
+
+			if _, statusCode, _ = VoidHold(ctx, login, hold.ID); statusCode != http.StatusConflict {
+				t.Fatalf("VoidHold() of a closed hold = %d, want %d", statusCode, http.StatusConflict)
+			}
+		})
+	}
+}
+
+func TestCreateHold_PaidOrder(t *testing.T) {
+	ctx := testContext(t)
+
+	login := testUser(t, ctx)
+	testCredit(t, ctx, login, 10)
+
+	order := testOrder()
+	if statusCode, err := Withdraw(ctx, login, &Wdraw{Order: order, Sum: 2}); statusCode != http.StatusOK {
+		t.Fatalf("Withdraw() = %d, %v", statusCode, err)
+	}
+
+	_, statusCode, err := CreateHold(ctx, login, &HoldRequest{Order: order, Sum: 2})
+	if statusCode != http.StatusConflict || err != ErrOrderPaid {
+		t.Fatalf("CreateHold() = %d, %v, want %d, %v", statusCode, err, http.StatusConflict, ErrOrderPaid)
+	}
+	wantBalance(t, ctx, login, 8, 2)
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// Ledger entry kinds, accruals and withdrawals live in their own tables
+const (
+	LedgerAdjustment = "adjustment"
+)
+
+// ErrInsufficientFunds
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// postEntry moves amount points to (or from, if negative) the balance of the user and records it in the ledger,
+// the balance never goes below zero
+func postEntry(ctx context.Context, q sqlx.ExtContext, login string, kind string, amount float32, orderID string, reference string) error {
+	if amount < 0 {
+		_, err := debitEntry(ctx, q, login, kind, -amount, orderID, reference)
+		return err
+	}
+
+	if err := addLot(ctx, q, login, kind, reference, amount); err != nil {
+		return err
+	}
+	return writeEntry(ctx, q, login, kind, amount, orderID, reference)
+}
+
+// debitEntry takes amount points off the balance and returns the lots they were taken from
+func debitEntry(ctx context.Context, q sqlx.ExtContext, login string, kind string, amount float32, orderID string, reference string) ([]lotTake, error) {
+	var balance float32
+	err := sqlx.GetContext(ctx, q, &balance, `SELECT balance FROM users WHERE username = $1 FOR UPDATE`, login)
+	if err != nil {
+		log.Err(err).Msg("lock balance error")
+		return nil, err
+	}
+	if balance < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	takes, err := consumeLots(ctx, q, login, balance, amount)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeEntry(ctx, q, login, kind, -amount, orderID, reference); err != nil {
+		return nil, err
+	}
+	return takes, nil
+}
+
+// restoreEntry credits back amount points of an earlier debit into the lots they were taken from,
+// no new lot is made so the points don't get a fresh expiry
+func restoreEntry(ctx context.Context, q sqlx.ExtContext, login string, kind string, amount float32, orderID string, reference string, takes []lotTake) error {
+	if err := restoreLots(ctx, q, takes); err != nil {
+		return err
+	}
+	return writeEntry(ctx, q, login, kind, amount, orderID, reference)
+}
+
+// carryEntry credits amount points debited from another user, the points taken from lots keep their expiry
+// and the untracked ones stay untracked
+func carryEntry(ctx context.Context, q sqlx.ExtContext, login string, kind string, amount float32, orderID string, reference string, takes []lotTake) error {
+	if err := carryLots(ctx, q, login, kind, reference, takes); err != nil {
+		return err
+	}
+	return writeEntry(ctx, q, login, kind, amount, orderID, reference)
+}
+
+// writeEntry
+func writeEntry(ctx context.Context, q sqlx.ExtContext, login string, kind string, amount float32, orderID string, reference string) error {
+	_, err := q.ExecContext(ctx, `UPDATE users SET balance = balance + $1 WHERE username = $2`, amount, login)
+	if err != nil {
+		log.Err(err).Msg("ledger balance update error")
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, `
+INSERT INTO ledger(username, kind, amount, order_id, reference) 
+VALUES ($1, $2, $3, $4, $5);`, login, kind, amount, orderID, reference)
+	if err != nil {
+		log.Err(err).Msg("ledger insert error")
+		return err
+	}
+
+	return publishBalance(ctx, q, login)
+}
@@ -0,0 +1,222 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/config"
+	"time"
+)
+
+// LedgerExpiration is written when a lot expires
+const LedgerExpiration = "expiration"
+
+// ExpiringPoints
+type ExpiringPoints struct {
+	Amount    float32   `json:"amount" db:"amount"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// lot
+type lot struct {
+	ID        int64      `db:"lot_id"`
+	Login     string     `db:"username"`
+	Remaining float32    `db:"remaining"`
+	ExpiresAt *time.Time `db:"expires_at"`
+}
+
+// lotTake is the part of a lot spent by a debit
+type lotTake struct {
+	LotID     int64      `db:"lot_id"`
+	Amount    float32    `db:"amount"`
+	ExpiresAt *time.Time `db:"expires_at"`
+}
+
+// addLot tracks credited points so they can expire, without a configured TTL they never do
+func addLot(ctx context.Context, q sqlx.ExtContext, login string, source string, reference string, amount float32) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	var expiresAt *time.Time
+	if ttl := config.Get().PointsTTL; ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	_, err := q.ExecContext(ctx, `
+INSERT INTO point_lots(username, source, reference, amount, remaining, expires_at) 
+VALUES ($1, $2, $3, $4, $4, $5);`, login, source, reference, amount, expiresAt)
+	if err != nil {
+		log.Err(err).Msg("add lot error")
+		return err
+	}
+	return nil
+}
+
+// carryLots credits the points taken from the lots of another user as lots expiring at the same time
+func carryLots(ctx context.Context, q sqlx.ExtContext, login string, source string, reference string, takes []lotTake) error {
+	for _, v := range takes {
+		_, err := q.ExecContext(ctx, `
+INSERT INTO point_lots(username, source, reference, amount, remaining, expires_at) 
+VALUES ($1, $2, $3, $4, $4, $5);`, login, source, reference, v.Amount, v.ExpiresAt)
+		if err != nil {
+			log.Err(err).Msg("carry lot error")
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeLots spends amount points first in, first out. Points credited before lots existed are
+// the oldest ones, so they go first. balance is the locked balance before the debit.
+// It returns the parts taken from every lot, the untracked points are not among them.
+func consumeLots(ctx context.Context, q sqlx.ExtContext, login string, balance float32, amount float32) ([]lotTake, error) {
+	var tracked float32
+	err := sqlx.GetContext(ctx, q, &tracked, `SELECT COALESCE(SUM(remaining), 0) FROM point_lots WHERE username = $1 AND remaining > 0`, login)
+	if err != nil {
+		log.Err(err).Msg("tracked points error")
+		return nil, err
+	}
+
+	rest := amount
+	if untracked := balance - tracked; untracked > 0 {
+		rest -= untracked
+	}
+	if rest <= 0 {
+		return nil, nil
+	}
+
+	lots := []lot{}
+	err = sqlx.SelectContext(ctx, q, &lots, `
+SELECT lot_id, username, remaining, expires_at FROM point_lots 
+WHERE username = $1 AND remaining > 0 
+ORDER BY earned_at, lot_id 
+FOR UPDATE;`, login)
+	if err != nil {
+		log.Err(err).Msg("select lots error")
+		return nil, err
+	}
+
+	takes := []lotTake{}
+	for _, v := range lots {
+		take := v.Remaining
+		if take > rest {
+			take = rest
+		}
+		if _, err = q.ExecContext(ctx, `UPDATE point_lots SET remaining = remaining - $1 WHERE lot_id = $2`, take, v.ID); err != nil {
+			log.Err(err).Msg("consume lot error")
+			return nil, err
+		}
+		takes = append(takes, lotTake{LotID: v.ID, Amount: take, ExpiresAt: v.ExpiresAt})
+		rest -= take
+		if rest <= 0 {
+			break
+		}
+	}
+	return takes, nil
+}
+
+// restoreLots puts the taken points back into their lots, so they keep the original expiry.
+// A lot that expired meanwhile is written off by the next ExpirePoints run.
+func restoreLots(ctx context.Context, q sqlx.ExtContext, takes []lotTake) error {
+	for _, v := range takes {
+		if _, err := q.ExecContext(ctx, `UPDATE point_lots SET remaining = remaining + $1 WHERE lot_id = $2`, v.Amount, v.LotID); err != nil {
+			log.Err(err).Msg("restore lot error")
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpirePoints writes off the rest of every expired lot
+func ExpirePoints(ctx context.Context) error {
+	lots := []lot{}
+	err := db.SelectContext(ctx, &lots, `
+SELECT lot_id, username, remaining FROM point_lots 
+WHERE remaining > 0 AND expires_at <= now() 
+ORDER BY expires_at 
+LIMIT 1000;`)
+	if err != nil {
+		log.Err(err).Msg("expired lots error")
+		return err
+	}
+
+	for _, v := range lots {
+		if err = expireLot(ctx, v); err != nil {
+			return err
+		}
+	}
+
+	if len(lots) > 0 {
+		log.Info().Msgf("%d lots expired", len(lots))
+	}
+	return nil
+}
+
+// expireLot
+func expireLot(ctx context.Context, v lot) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var balance float32
+	if err = tx.GetContext(ctx, &balance, `SELECT balance FROM users WHERE username = $1 FOR UPDATE`, v.Login); err != nil {
+		log.Err(err).Msg("lock balance error")
+		return err
+	}
+
+	var remaining float32
+	if err = tx.GetContext(ctx, &remaining, `SELECT remaining FROM point_lots WHERE lot_id = $1 FOR UPDATE`, v.ID); err != nil {
+		log.Err(err).Msg("lock lot error")
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE point_lots SET remaining = 0 WHERE lot_id = $1`, v.ID); err != nil {
+		log.Err(err).Msg("expire lot error")
+		return err
+	}
+
+	if remaining > balance {
+		remaining = balance
+	}
+	if remaining <= 0 {
+		return tx.Commit()
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET balance = balance - $1 WHERE username = $2`, remaining, v.Login); err != nil {
+		log.Err(err).Msg("expire balance error")
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO ledger(username, kind, amount, reference) 
+VALUES ($1, $2, $3, $4);`, v.Login, LedgerExpiration, -remaining, fmt.Sprintf("lot #%d", v.ID))
+	if err != nil {
+		log.Err(err).Msg("expiration ledger error")
+		return err
+	}
+
+	if err = publishBalance(ctx, tx, v.Login); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetExpiringPoints returns the points expiring within the period, grouped by day
+func GetExpiringPoints(ctx context.Context, login string, within time.Duration) ([]ExpiringPoints, error) {
+	res := []ExpiringPoints{}
+	err := db.SelectContext(ctx, &res, `
+SELECT SUM(remaining) AS amount, date_trunc('day', expires_at) AS expires_at 
+FROM point_lots 
+WHERE username = $1 AND remaining > 0 AND expires_at IS NOT NULL AND expires_at <= $2 
+GROUP BY date_trunc('day', expires_at) 
+ORDER BY expires_at;`, login, time.Now().Add(within))
+	if err != nil {
+		log.Err(err).Msg("expiring points error")
+		return nil, err
+	}
+	return res, nil
+}
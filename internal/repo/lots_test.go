@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWithdraw_Lots(t *testing.T) {
+	ctx := testContext(t)
+
+	tests := []struct {
+		name          string
+		credits       []float32
+		untracked     float32
+		withdraw      float32
+		wantRemaining []float32
+	}{
+		{
+			name:          "success test #1",
+			credits:       []float32{10, 20},
+			withdraw:      15,
+			wantRemaining: []float32{0, 15},
+		},
+		{
+			name:          "success test #2",
+			credits:       []float32{10, 20},
+			withdraw:      30,
+			wantRemaining: []float32{0, 0},
+		},
+		{
+			name:          "untracked points go first",
+			credits:       []float32{10},
+			untracked:     5,
+			withdraw:      7,
+			wantRemaining: []float32{8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := testUser(t, ctx)
+			if tt.untracked > 0 {
+				if _, err := db.ExecContext(ctx, `UPDATE users SET balance = balance + $1 WHERE username = $2`, tt.untracked, login); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var total float32
+			for _, v := range tt.credits {
+				testCredit(t, ctx, login, v)
+				total += v
+			}
+
+			statusCode, err := Withdraw(ctx, login, &Wdraw{Order: testOrder(), Sum: tt.withdraw})
+			if statusCode != http.StatusOK {
+				t.Fatalf("Withdraw() = %d, %v", statusCode, err)
+			}
+
+			wantRemaining(t, ctx, login, tt.wantRemaining)
+			wantBalance(t, ctx, login, total+tt.untracked-tt.withdraw, tt.withdraw)
+		})
+	}
+}
+
+func TestWithdraw_InsufficientFunds(t *testing.T) {
+	ctx := testContext(t)
+
+	login := testUser(t, ctx)
+	testCredit(t, ctx, login, 10)
+
+	statusCode, err := Withdraw(ctx, login, &Wdraw{Order: testOrder(), Sum: 11})
+	if statusCode != http.StatusPaymentRequired {
+		t.Fatalf("Withdraw() = %d, %v, want %d", statusCode, err, http.StatusPaymentRequired)
+	}
+	wantRemaining(t, ctx, login, []float32{10})
+	wantBalance(t, ctx, login, 10, 0)
+}
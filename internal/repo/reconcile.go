@@ -0,0 +1,455 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/accrual"
+	"github.com/lekan/gophermart/internal/config"
+	"math"
+	"time"
+)
+
+const (
+	ReconciliationRunning  = "RUNNING"
+	ReconciliationFinished = "FINISHED"
+	ReconciliationFailed   = "FAILED"
+
+	AdjustmentPending  = "PENDING"
+	AdjustmentApproved = "APPROVED"
+	AdjustmentRejected = "REJECTED"
+)
+
+const (
+	reconcileMaxOrders = 10000
+	reconcileRetries   = 3
+	reconcileMaxWait   = time.Minute
+	// reconcileLockKey is the namespace of the advisory locks of the runs
+	reconcileLockKey = 3301
+	// accrualTolerance hides the rounding of NUMERIC to float32
+	accrualTolerance = 0.005
+)
+
+// ReconcileOptions
+type ReconcileOptions struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Sample int       `json:"sample"`
+	Adjust bool      `json:"adjust"`
+}
+
+// Reconciliation
+type Reconciliation struct {
+	ID         int        `json:"id" db:"reconciliation_id"`
+	From       time.Time  `json:"from" db:"window_from"`
+	To         time.Time  `json:"to" db:"window_to"`
+	Sample     int        `json:"sample" db:"sample_size"`
+	Adjust     bool       `json:"adjust" db:"adjust"`
+	Status     string     `json:"status" db:"status"`
+	Checked    int        `json:"checked" db:"checked"`
+	Mismatches int        `json:"mismatches" db:"mismatches"`
+	Errors     int        `json:"errors" db:"errors"`
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+	TenantID   string     `json:"-" db:"tenant_id"`
+}
+
+// ReconciliationItem is an order whose accrual differs from the accrual system or couldn't be checked
+type ReconciliationItem struct {
+	OrderID       string  `json:"order" db:"order_id"`
+	Login         string  `json:"login" db:"username"`
+	StoredAccrual float32 `json:"stored_accrual" db:"stored_accrual"`
+	RemoteStatus  string  `json:"remote_status" db:"remote_status"`
+	RemoteAccrual float32 `json:"remote_accrual" db:"remote_accrual"`
+	Difference    float32 `json:"difference" db:"difference"`
+	Error         string  `json:"error,omitempty" db:"error"`
+}
+
+// Adjustment is a compensating ledger entry waiting for an admin decision
+type Adjustment struct {
+	ID               int        `json:"id" db:"adjustment_id"`
+	Login            string     `json:"login" db:"username"`
+	OrderID          string     `json:"order,omitempty" db:"order_id"`
+	Amount           float32    `json:"amount" db:"amount"`
+	Reason           string     `json:"reason" db:"reason"`
+	ReconciliationID *int       `json:"reconciliation_id,omitempty" db:"reconciliation_id"`
+	Status           string     `json:"status" db:"status"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	DecidedAt        *time.Time `json:"decided_at,omitempty" db:"decided_at"`
+}
+
+// StartReconciliation registers a run over the orders of the tenant, the work is done by RunReconciliation
+func StartReconciliation(ctx context.Context, opts ReconcileOptions) (Reconciliation, error) {
+	var rec Reconciliation
+	err := db.GetContext(ctx, &rec, `
+INSERT INTO reconciliations(window_from, window_to, sample_size, adjust, tenant_id) 
+VALUES ($1, $2, $3, $4, $5) 
+RETURNING *;`, opts.From, opts.To, opts.Sample, opts.Adjust, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("start reconciliation error")
+		return Reconciliation{}, err
+	}
+	return rec, nil
+}
+
+// RunReconciliation re-queries PROCESSED orders of the window and records the differences
+func RunReconciliation(ctx context.Context, rec Reconciliation) error {
+	err := runReconciliation(withTenantID(ctx, rec.TenantID), &rec)
+
+	status := ReconciliationFinished
+	if err != nil {
+		log.Err(err).Msgf("reconciliation %d error", rec.ID)
+		status = ReconciliationFailed
+	}
+
+	_, errUpd := db.ExecContext(context.Background(), `
+UPDATE reconciliations 
+SET status = $2, checked = $3, mismatches = $4, errors = $5, finished_at = now() 
+WHERE reconciliation_id = $1;`, rec.ID, status, rec.Checked, rec.Mismatches, rec.Errors)
+	if errUpd != nil {
+		log.Err(errUpd).Msg("finish reconciliation error")
+		return errUpd
+	}
+	return err
+}
+
+// processedOrder
+type processedOrder struct {
+	OrderID string  `db:"order_id"`
+	Login   string  `db:"username"`
+	Accrual float32 `db:"accrual"`
+}
+
+func runReconciliation(ctx context.Context, rec *Reconciliation) error {
+	limit := reconcileMaxOrders
+	if rec.Sample > 0 && rec.Sample < limit {
+		limit = rec.Sample
+	}
+
+	// начисление с учётом уже созданных корректировок, чтобы не корректировать дважды
+	orders := []processedOrder{}
+	err := db.SelectContext(ctx, &orders, `
+SELECT o.order_id, o.username, 
+       o.accrual + COALESCE((SELECT SUM(a.amount) FROM adjustments a 
+                             WHERE a.order_id = o.order_id AND a.username = o.username AND a.status <> 'REJECTED'), 0) AS accrual 
+FROM orders o 
+WHERE o.tenant_id = $5 AND o.status = 'PROCESSED' AND o.uploaded_at >= $1::timestamptz AND o.uploaded_at < $2::timestamptz 
+ORDER BY CASE WHEN $3 THEN random() END, o.uploaded_at 
+LIMIT $4;`, rec.From, rec.To, rec.Sample > 0, limit, tenantID(ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		item := ReconciliationItem{
+			OrderID:       order.OrderID,
+			Login:         order.Login,
+			StoredAccrual: order.Accrual,
+		}
+
+		remote, err := getOrderPatiently(ctx, order.OrderID)
+		rec.Checked++
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, accrual.ErrNotRegistered):
+			item.RemoteStatus = "UNKNOWN"
+		case err != nil:
+			item.Error = err.Error()
+		default:
+			item.RemoteStatus = remote.Status
+			if remote.Status == accrual.StatusProcessed {
+				item.RemoteAccrual = remote.Accrual
+			}
+		}
+
+		if item.Error == "" {
+			diff := float64(item.RemoteAccrual) - float64(item.StoredAccrual)
+			if math.Abs(diff) < accrualTolerance {
+				continue
+			}
+			item.Difference = float32(math.Round(diff*100) / 100)
+			rec.Mismatches++
+		} else {
+			rec.Errors++
+		}
+
+		if err = addReconciliationItem(ctx, rec, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getOrderPatiently waits out the rate limit of the accrual system of the tenant
+func getOrderPatiently(ctx context.Context, number string) (accrual.Order, error) {
+	client, err := clientFor(ctx)
+	if err != nil {
+		return accrual.Order{}, err
+	}
+
+	var rateLimit *accrual.RateLimitError
+	for i := 0; ; i++ {
+		order, err := client.GetOrder(ctx, number)
+		if !errors.As(err, &rateLimit) || i == reconcileRetries {
+			return order, err
+		}
+
+		wait := rateLimit.RetryAfter
+		if wait > reconcileMaxWait {
+			wait = reconcileMaxWait
+		}
+		select {
+		case <-ctx.Done():
+			return order, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// addReconciliationItem stores the item and proposes a compensating adjustment if asked to
+func addReconciliationItem(ctx context.Context, rec *Reconciliation, item ReconciliationItem) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO reconciliation_items(reconciliation_id, order_id, username, stored_accrual, remote_status, remote_accrual, difference, error) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`, rec.ID, item.OrderID, item.Login, item.StoredAccrual,
+		item.RemoteStatus, item.RemoteAccrual, item.Difference, item.Error)
+	if err != nil {
+		log.Err(err).Msg("reconciliation item error")
+		return err
+	}
+
+	if rec.Adjust && item.Error == "" {
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO adjustments(username, order_id, amount, reason, reconciliation_id) 
+VALUES ($1, $2, $3, $4, $5);`, item.Login, item.OrderID, item.Difference,
+			fmt.Sprintf("reconciliation #%d: stored %.2f, accrual system %.2f", rec.ID, item.StoredAccrual, item.RemoteAccrual), rec.ID)
+		if err != nil {
+			log.Err(err).Msg("adjustment error")
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ErrReconcileRunning is returned while another run of the tenant goes on
+var ErrReconcileRunning = errors.New("the tenant is being reconciled")
+
+// ScheduledReconciliation checks the configured window that ends now for every active tenant.
+// Every instance runs it, a tenant is reconciled once per interval by whichever instance comes first.
+func ScheduledReconciliation(ctx context.Context) error {
+	c := config.Get()
+	now := time.Now()
+
+	tenants, err := GetTenants(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		if !t.Active {
+			continue
+		}
+		if err = scheduledReconciliation(WithTenant(ctx, t), ReconcileOptions{
+			From:   now.Add(-c.ReconcileWindow),
+			To:     now,
+			Sample: c.ReconcileSample,
+			Adjust: c.ReconcileAdjust,
+		}, c.ReconcileInterval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduledReconciliation runs when the last scheduled run of the tenant is older than the interval,
+// the tickers of the instances are not aligned so a tenth of it is given away
+func scheduledReconciliation(ctx context.Context, opts ReconcileOptions, interval time.Duration) error {
+	release, err := lockReconciliation(ctx)
+	if errors.Is(err, ErrReconcileRunning) {
+		log.Info().Msgf("tenant %s is reconciled by another instance", tenantID(ctx))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var claimed []string
+	err = db.SelectContext(ctx, &claimed, `
+INSERT INTO reconcile_schedule(tenant_id, last_run_at) VALUES ($1, now()) 
+ON CONFLICT (tenant_id) DO UPDATE SET last_run_at = now() 
+WHERE reconcile_schedule.last_run_at <= now() - $2 * interval '1 second' 
+RETURNING tenant_id;`, tenantID(ctx), (interval - interval/10).Seconds())
+	if err != nil {
+		log.Err(err).Msg("reconcile schedule error")
+		return err
+	}
+	if len(claimed) == 0 {
+		log.Info().Msgf("tenant %s was reconciled recently", tenantID(ctx))
+		return nil
+	}
+
+	rec, err := StartReconciliation(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return RunReconciliation(ctx, rec)
+}
+
+// LaunchReconciliation starts a run of the tenant in the background, a tenant has one run at a time
+func LaunchReconciliation(ctx context.Context, opts ReconcileOptions) (Reconciliation, error) {
+	release, err := lockReconciliation(ctx)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	rec, err := StartReconciliation(ctx, opts)
+	if err != nil {
+		release()
+		return Reconciliation{}, err
+	}
+
+	// сверка может идти дольше запроса
+	go func() {
+		defer release()
+		_ = RunReconciliation(context.Background(), rec)
+	}()
+	return rec, nil
+}
+
+// lockReconciliation takes the advisory lock of the tenant on a connection of its own,
+// release gives back both
+func lockReconciliation(ctx context.Context) (func(), error) {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id := tenantID(ctx)
+	var locked bool
+	if err = conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1, hashtext($2))`, reconcileLockKey, id); err != nil {
+		log.Err(err).Msg("reconciliation lock error")
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrReconcileRunning
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, reconcileLockKey, id); err != nil {
+			log.Err(err).Msg("reconciliation unlock error")
+		}
+		conn.Close()
+	}, nil
+}
+
+// GetReconciliations
+func GetReconciliations(ctx context.Context) ([]Reconciliation, error) {
+	recs := []Reconciliation{}
+	err := db.SelectContext(ctx, &recs, `
+SELECT * FROM reconciliations 
+WHERE tenant_id = $1 
+ORDER BY reconciliation_id DESC 
+LIMIT 100;`, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get reconciliations error")
+		return nil, err
+	}
+	return recs, nil
+}
+
+// GetReconciliation
+func GetReconciliation(ctx context.Context, id int) (Reconciliation, error) {
+	var rec Reconciliation
+	err := db.GetContext(ctx, &rec, `SELECT * FROM reconciliations WHERE reconciliation_id = $1 AND tenant_id = $2`, id, tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Reconciliation{}, ErrNotFound
+		}
+		log.Err(err).Msg("get reconciliation error")
+		return Reconciliation{}, err
+	}
+	return rec, nil
+}
+
+// GetReconciliationItems
+func GetReconciliationItems(ctx context.Context, id int) ([]ReconciliationItem, error) {
+	items := []ReconciliationItem{}
+	err := db.SelectContext(ctx, &items, `
+SELECT i.order_id, i.username, i.stored_accrual, i.remote_status, i.remote_accrual, i.difference, i.error 
+FROM reconciliation_items i JOIN reconciliations r ON r.reconciliation_id = i.reconciliation_id 
+WHERE i.reconciliation_id = $1 AND r.tenant_id = $2 
+ORDER BY i.username, i.order_id;`, id, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get reconciliation items error")
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetAdjustments
+func GetAdjustments(ctx context.Context, status string) ([]Adjustment, error) {
+	adjustments := []Adjustment{}
+	err := db.SelectContext(ctx, &adjustments, `
+SELECT * FROM adjustments 
+WHERE ($1 = '' OR status = $1) AND username IN (SELECT username FROM users WHERE tenant_id = $2) 
+ORDER BY adjustment_id DESC 
+LIMIT 500;`, status, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get adjustments error")
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+// DecideAdjustment approves (posting it to the ledger) or rejects a pending adjustment
+func DecideAdjustment(ctx context.Context, id int, approve bool) (Adjustment, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Adjustment{}, err
+	}
+	defer tx.Rollback()
+
+	status := AdjustmentRejected
+	if approve {
+		status = AdjustmentApproved
+	}
+
+	var adj Adjustment
+	err = tx.GetContext(ctx, &adj, `
+UPDATE adjustments SET status = $2, decided_at = now() 
+WHERE adjustment_id = $1 AND status = 'PENDING' 
+	AND username IN (SELECT username FROM users WHERE tenant_id = $3) 
+RETURNING *;`, id, status, tenantID(ctx))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Adjustment{}, ErrNotFound
+		}
+		log.Err(err).Msg("decide adjustment error")
+		return Adjustment{}, err
+	}
+
+	if approve {
+		err = postEntry(ctx, tx, adj.Login, LedgerAdjustment, adj.Amount, adj.OrderID, fmt.Sprintf("adjustment #%d", adj.ID))
+		if err != nil {
+			return Adjustment{}, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Adjustment{}, err
+	}
+	return adj, nil
+}
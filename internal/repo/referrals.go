@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/codegen"
+	"github.com/lekan/gophermart/internal/config"
+	"strings"
+	"time"
+)
+
+const (
+	LedgerReferralBonus = "referral_bonus"
+
+	referralCodeLength = 8
+)
+
+// Referral statuses
+const (
+	ReferralPending  = "PENDING"
+	ReferralRewarded = "REWARDED"
+	ReferralRejected = "REJECTED"
+)
+
+// ErrBadReferralCode
+var ErrBadReferralCode = errors.New("unknown referral code")
+
+// Referral
+type Referral struct {
+	Referred  string     `json:"login" db:"referred"`
+	Status    string     `json:"status" db:"status"`
+	Reason    string     `json:"reason,omitempty" db:"reason"`
+	Bonus     float32    `json:"bonus,omitempty" db:"referrer_bonus"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	Rewarded  *time.Time `json:"rewarded_at,omitempty" db:"rewarded_at"`
+}
+
+// Referrals of the user and the code to share
+type Referrals struct {
+	Code      string     `json:"code"`
+	Referrals []Referral `json:"referrals"`
+}
+
+// addReferral links the new user to the owner of the code
+func addReferral(ctx context.Context, tx *sqlx.Tx, login string, code string) error {
+	var referrer string
+	err := tx.GetContext(ctx, &referrer, `SELECT username FROM users WHERE referral_code = $1 AND tenant_id = $2`,
+		codegen.Normalize(code), tenantID(ctx))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrBadReferralCode
+		}
+		log.Err(err).Msg("referral code lookup error")
+		return err
+	}
+	if strings.EqualFold(referrer, login) {
+		return ErrBadReferralCode
+	}
+
+	if _, err = tx.ExecContext(ctx, `INSERT INTO referrals(referrer, referred) VALUES ($1, $2)`, referrer, login); err != nil {
+		log.Err(err).Msg("referral insert error")
+		return err
+	}
+	return nil
+}
+
+// applyReferral rewards both parties when the first order of the referred user is processed
+func applyReferral(ctx context.Context, tx *sqlx.Tx, login string, order Order) error {
+	var ref struct {
+		ID       int    `db:"referral_id"`
+		Referrer string `db:"referrer"`
+	}
+	err := tx.GetContext(ctx, &ref, `
+SELECT referral_id, referrer FROM referrals 
+WHERE referred = $1 AND status = 'PENDING' 
+FOR UPDATE;`, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		log.Err(err).Msg("referral lookup error")
+		return err
+	}
+
+	var processed int
+	err = tx.GetContext(ctx, &processed, `
+SELECT COUNT(*) FROM orders WHERE username = $1 AND status = 'PROCESSED' AND order_id <> $2`, login, order.OrderID)
+	if err != nil {
+		log.Err(err).Msg("processed orders count error")
+		return err
+	}
+	if processed > 0 {
+		return nil
+	}
+
+	c := config.Get()
+	if c.ReferralMaxPerUser > 0 {
+		var rewarded int
+		err = tx.GetContext(ctx, &rewarded, `
+SELECT COUNT(*) FROM referrals WHERE referrer = $1 AND status = 'REWARDED'`, ref.Referrer)
+		if err != nil {
+			log.Err(err).Msg("rewarded referrals count error")
+			return err
+		}
+		if rewarded >= c.ReferralMaxPerUser {
+			_, err = tx.ExecContext(ctx, `
+UPDATE referrals SET status = 'REJECTED', reason = 'referral cap reached' WHERE referral_id = $1`, ref.ID)
+			return err
+		}
+	}
+
+	reference := fmt.Sprintf("referral #%d", ref.ID)
+	if c.ReferralReferrerBonus > 0 {
+		if err = postEntry(ctx, tx, ref.Referrer, LedgerReferralBonus, c.ReferralReferrerBonus, "", reference); err != nil {
+			return err
+		}
+	}
+	if c.ReferralReferredBonus > 0 {
+		if err = postEntry(ctx, tx, login, LedgerReferralBonus, c.ReferralReferredBonus, order.OrderID, reference); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE referrals SET status = 'REWARDED', referrer_bonus = $1, referred_bonus = $2, rewarded_at = now() 
+WHERE referral_id = $3;`, c.ReferralReferrerBonus, c.ReferralReferredBonus, ref.ID)
+	if err != nil {
+		log.Err(err).Msg("referral update error")
+	}
+	return err
+}
+
+// GetReferrals returns the code of the user and the users who came with it,
+// users registered before the program get their code here
+func GetReferrals(ctx context.Context, login string) (Referrals, error) {
+	res := Referrals{Referrals: []Referral{}}
+
+	var code sql.NullString
+	if err := db.GetContext(ctx, &code, `SELECT referral_code FROM users WHERE username = $1`, login); err != nil {
+		log.Err(err).Msg("referral code error")
+		return Referrals{}, err
+	}
+	res.Code = code.String
+
+	if !code.Valid {
+		newCode, err := codegen.New(referralCodeLength)
+		if err != nil {
+			return Referrals{}, err
+		}
+		err = db.GetContext(ctx, &res.Code, `
+UPDATE users SET referral_code = COALESCE(referral_code, $1) WHERE username = $2 
+RETURNING referral_code;`, newCode, login)
+		if err != nil {
+			log.Err(err).Msg("referral code update error")
+			return Referrals{}, err
+		}
+	}
+
+	err := db.SelectContext(ctx, &res.Referrals, `
+SELECT referred, status, reason, referrer_bonus, created_at, rewarded_at FROM referrals 
+WHERE referrer = $1 
+ORDER BY created_at;`, login)
+	if err != nil {
+		log.Err(err).Msg("get referrals error")
+		return Referrals{}, err
+	}
+	return res, nil
+}
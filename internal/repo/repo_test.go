@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/luhn"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv names the database of the tests that need Postgres, without it they are skipped.
+// The schema is created in it like at startup, every test makes its own users.
+const testDatabaseEnv = "TEST_DATABASE_URI"
+
+var (
+	setupOnce sync.Once
+	setupErr  error
+	testSeq   int64
+)
+
+// testContext connects to the test database once and returns the context of the default tenant
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+
+	uri := os.Getenv(testDatabaseEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+	setupOnce.Do(func() {
+		config.New()
+		setupErr = New(uri)
+	})
+	if setupErr != nil {
+		t.Fatalf("database setup error: %v", setupErr)
+	}
+	return context.Background()
+}
+
+// testSuffix is unique within the run and between runs against the same database
+func testSuffix() string {
+	return fmt.Sprintf("%d_%d", time.Now().UnixNano(), atomic.AddInt64(&testSeq, 1))
+}
+
+// testUser registers a new user
+func testUser(t *testing.T, ctx context.Context) string {
+	t.Helper()
+
+	login := "test_" + testSuffix()
+	if err := Signup(ctx, &Credentials{Login: login, Password: "password"}); err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	return login
+}
+
+// testOrder makes a Luhn valid order number nobody has used
+func testOrder() string {
+	base := int(time.Now().UnixNano()%1e12) + int(atomic.AddInt64(&testSeq, 1))
+	return strconv.Itoa(base*10 + luhn.CalculateLuhn(base))
+}
+
+// testCredit credits amount points as a new lot
+func testCredit(t *testing.T, ctx context.Context, login string, amount float32) {
+	t.Helper()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err = postEntry(ctx, tx, login, LedgerAdjustment, amount, "", "test"); err != nil {
+		t.Fatalf("postEntry() error = %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testBalance
+func testBalance(t *testing.T, ctx context.Context, login string) Balance {
+	t.Helper()
+
+	b, err := GetBalance(ctx, login)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	return b
+}
+
+// testLots returns what is left in the lots of the user, oldest first
+func testLots(t *testing.T, ctx context.Context, login string) []lot {
+	t.Helper()
+
+	res := []lot{}
+	err := db.SelectContext(ctx, &res, `
+SELECT lot_id, username, remaining, expires_at FROM point_lots
+WHERE username = $1
+ORDER BY earned_at, lot_id;`, login)
+	if err != nil {
+		t.Fatalf("lots error: %v", err)
+	}
+	return res
+}
+
+// wantBalance fails the test when the balance differs
+func wantBalance(t *testing.T, ctx context.Context, login string, current float32, withdrawn float32) {
+	t.Helper()
+
+	b := testBalance(t, ctx, login)
+	if b.Current != current || b.Withdrawn != withdrawn {
+		t.Fatalf("balance = %v/%v, want %v/%v", b.Current, b.Withdrawn, current, withdrawn)
+	}
+}
+
+// wantRemaining fails the test when the lots of the user hold other amounts
+func wantRemaining(t *testing.T, ctx context.Context, login string, want []float32) {
+	t.Helper()
+
+	lots := testLots(t, ctx, login)
+	if len(lots) != len(want) {
+		t.Fatalf("got %d lots, want %d", len(lots), len(want))
+	}
+	for i := range lots {
+		if lots[i].Remaining != want[i] {
+			t.Fatalf("lot #%d remaining = %v, want %v", i, lots[i].Remaining, want[i])
+		}
+	}
+}
+
+// sumOf is for the optional sums of requests
+func sumOf(v float32) *float32 {
+	return &v
+}
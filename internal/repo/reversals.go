@@ -0,0 +1,226 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// LedgerReversal is written when withdrawn points come back
+const LedgerReversal = "reversal"
+
+// ErrOverReversal is returned when more would be reversed than was withdrawn
+var ErrOverReversal = errors.New("reversal exceeds the withdrawal")
+
+// ErrBadAmount
+var ErrBadAmount = errors.New("amount must be positive")
+
+// ErrPartialRedemption is returned when a part of a catalog redemption would be reversed, the item can't be restocked by parts
+var ErrPartialRedemption = errors.New("a catalog redemption is reversed only as a whole")
+
+// Reversal
+type Reversal struct {
+	ID           int       `json:"id" db:"reversal_id"`
+	WithdrawalID int       `json:"withdrawal_id" db:"operation_id"`
+	Login        string    `json:"login" db:"username"`
+	Order        string    `json:"order" db:"order_id"`
+	Sum          float32   `json:"sum" db:"reversal_sum"`
+	Reason       string    `json:"reason,omitempty" db:"reason"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// ReverseRequest, without Sum the whole rest of the withdrawal is reversed
+type ReverseRequest struct {
+	Sum    *float32 `json:"sum,omitempty"`
+	Reason string   `json:"reason"`
+}
+
+// withdrawalRow
+type withdrawalRow struct {
+	ID          int     `db:"operation_id"`
+	Login       string  `db:"username"`
+	Sum         float32 `db:"withdraw_sum"`
+	Reversed    float32 `db:"reversed"`
+	LotsTracked bool    `db:"lots_tracked"`
+}
+
+// ReverseWithdrawal returns points of the latest withdrawal for the order to the user
+func ReverseWithdrawal(ctx context.Context, order string, req ReverseRequest) (Reversal, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Reversal{}, err
+	}
+	defer tx.Rollback()
+
+	var w withdrawalRow
+	err = tx.GetContext(ctx, &w, `
+SELECT operation_id, username, withdraw_sum, reversed, lots_tracked FROM withdrawals 
+WHERE order_id = $1 AND tenant_id = $2 AND reversed < withdraw_sum 
+ORDER BY processed_at DESC, operation_id DESC 
+LIMIT 1 
+FOR UPDATE;`, order, tenantID(ctx))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Err(err).Msg("reversal withdrawal lookup error")
+			return Reversal{}, err
+		}
+
+		var exists bool
+		err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM withdrawals WHERE order_id = $1 AND tenant_id = $2)`,
+			order, tenantID(ctx))
+		if err != nil {
+			return Reversal{}, err
+		}
+		if exists {
+			return Reversal{}, ErrOverReversal
+		}
+		return Reversal{}, ErrNotFound
+	}
+
+	rest := w.Sum - w.Reversed
+	sum := rest
+	if req.Sum != nil {
+		sum = *req.Sum
+	}
+	if sum <= 0 {
+		return Reversal{}, ErrBadAmount
+	}
+	if sum > rest {
+		return Reversal{}, ErrOverReversal
+	}
+
+	if err = restockRedemption(ctx, tx, w.Login, order, sum < w.Sum); err != nil {
+		return Reversal{}, err
+	}
+
+	var rev Reversal
+	err = tx.GetContext(ctx, &rev, `
+INSERT INTO withdrawal_reversals(operation_id, username, order_id, reversal_sum, reason) 
+VALUES ($1, $2, $3, $4, $5) 
+RETURNING *;`, w.ID, w.Login, order, sum, req.Reason)
+	if err != nil {
+		log.Err(err).Msg("reversal insert error")
+		return Reversal{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE withdrawals SET reversed = reversed + $1 WHERE operation_id = $2`, sum, w.ID); err != nil {
+		log.Err(err).Msg("withdrawal reversed update error")
+		return Reversal{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE users SET withdrawn = withdrawn - $1 WHERE username = $2`, sum, w.Login); err != nil {
+		log.Err(err).Msg("withdrawn update error")
+		return Reversal{}, err
+	}
+
+	reference := fmt.Sprintf("reversal #%d", rev.ID)
+	if w.LotsTracked {
+		takes, err := takeWithdrawalLots(ctx, tx, w.ID, sum)
+		if err != nil {
+			return Reversal{}, err
+		}
+		err = restoreEntry(ctx, tx, w.Login, LedgerReversal, sum, order, reference, takes)
+		if err != nil {
+			return Reversal{}, err
+		}
+	} else if err = postEntry(ctx, tx, w.Login, LedgerReversal, sum, order, reference); err != nil {
+		// у выводов до учёта партий взятые партии неизвестны
+		return Reversal{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Reversal{}, err
+	}
+	return rev, nil
+}
+
+// recordWithdrawalLots remembers the lots a withdrawal was taken from, so a reversal puts the points back into them
+func recordWithdrawalLots(ctx context.Context, tx *sqlx.Tx, operationID int, takes []lotTake) error {
+	for _, v := range takes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO withdrawal_lots(operation_id, lot_id, amount) VALUES ($1, $2, $3)`,
+			operationID, v.LotID, v.Amount)
+		if err != nil {
+			log.Err(err).Msg("withdrawal lot insert error")
+			return err
+		}
+	}
+	return nil
+}
+
+// takeWithdrawalLots picks the lots to restore sum points of the withdrawal into, in the reverse order of spending.
+// The untracked points were spent first, so they come back last and stay untracked.
+func takeWithdrawalLots(ctx context.Context, tx *sqlx.Tx, operationID int, sum float32) ([]lotTake, error) {
+	takes := []lotTake{}
+	err := tx.SelectContext(ctx, &takes, `
+SELECT wl.lot_id, wl.amount - wl.restored AS amount, p.expires_at 
+FROM withdrawal_lots wl JOIN point_lots p ON p.lot_id = wl.lot_id 
+WHERE wl.operation_id = $1 AND wl.restored < wl.amount 
+ORDER BY p.earned_at DESC, p.lot_id DESC 
+FOR UPDATE OF wl;`, operationID)
+	if err != nil {
+		log.Err(err).Msg("withdrawal lots error")
+		return nil, err
+	}
+
+	res := []lotTake{}
+	for _, v := range takes {
+		if sum <= 0 {
+			break
+		}
+		if v.Amount > sum {
+			v.Amount = sum
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE withdrawal_lots SET restored = restored + $1 WHERE operation_id = $2 AND lot_id = $3`,
+			v.Amount, operationID, v.LotID)
+		if err != nil {
+			log.Err(err).Msg("withdrawal lot restore error")
+			return nil, err
+		}
+		res = append(res, v)
+		sum -= v.Amount
+	}
+	return res, nil
+}
+
+// restockRedemption returns the redeemed items to the stock when the withdrawal paid for a catalog redemption
+func restockRedemption(ctx context.Context, tx *sqlx.Tx, login string, order string, partial bool) error {
+	var red CatalogRedemption
+	err := tx.GetContext(ctx, &red, `
+SELECT redemption_id, item_id, quantity FROM catalog_redemptions 
+WHERE order_id = $1 AND username = $2;`, order, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		log.Err(err).Msg("redemption lookup error")
+		return err
+	}
+	if partial {
+		return ErrPartialRedemption
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE catalog_items SET stock = stock + $1 WHERE item_id = $2`, red.Quantity, red.ItemID); err != nil {
+		log.Err(err).Msg("restock error")
+		return err
+	}
+	return nil
+}
+
+// GetReversals
+func GetReversals(ctx context.Context, order string) ([]Reversal, error) {
+	res := []Reversal{}
+	err := db.SelectContext(ctx, &res, `
+SELECT r.* FROM withdrawal_reversals r 
+JOIN withdrawals w ON w.operation_id = r.operation_id 
+WHERE r.order_id = $1 AND w.tenant_id = $2 
+ORDER BY r.reversal_id;`, order, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("get reversals error")
+		return nil, err
+	}
+	return res, nil
+}
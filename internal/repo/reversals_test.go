@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"errors"
+	"github.com/lekan/gophermart/internal/config"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReverseWithdrawal(t *testing.T) {
+	ctx := testContext(t)
+
+	c := config.Get()
+	ttl := c.PointsTTL
+	c.PointsTTL = time.Hour
+	defer func() { c.PointsTTL = ttl }()
+
+	login := testUser(t, ctx)
+	testCredit(t, ctx, login, 10)
+	testCredit(t, ctx, login, 10)
+	before := testLots(t, ctx, login)
+
+	order := testOrder()
+	if statusCode, err := Withdraw(ctx, login, &Wdraw{Order: order, Sum: 15}); statusCode != http.StatusOK {
+		t.Fatalf("Withdraw() = %d, %v", statusCode, err)
+	}
+	wantRemaining(t, ctx, login, []float32{0, 5})
+
+	// последними потрачены баллы второй партии, они и возвращаются первыми
+	if _, err := ReverseWithdrawal(ctx, order, ReverseRequest{Sum: sumOf(7)}); err != nil {
+		t.Fatalf("ReverseWithdrawal() error = %v", err)
+	}
+	wantRemaining(t, ctx, login, []float32{2, 10})
+	wantBalance(t, ctx, login, 12, 8)
+
+	if _, err := ReverseWithdrawal(ctx, order, ReverseRequest{}); err != nil {
+		t.Fatalf("ReverseWithdrawal() error = %v", err)
+	}
+	wantRemaining(t, ctx, login, []float32{10, 10})
+	wantBalance(t, ctx, login, 20, 0)
+
+	after := testLots(t, ctx, login)
+	for i := range after {
+		if !after[i].ExpiresAt.Equal(*before[i].ExpiresAt) {
+			t.Fatalf("lot #%d expires at %v, want %v", i, after[i].ExpiresAt, before[i].ExpiresAt)
+		}
+	}
+
+	if _, err := ReverseWithdrawal(ctx, order, ReverseRequest{}); !errors.Is(err, ErrOverReversal) {
+		t.Fatalf("ReverseWithdrawal() error = %v, want %v", err, ErrOverReversal)
+	}
+}
+
+func TestReverseWithdrawal_Redemption(t *testing.T) {
+	ctx := testContext(t)
+
+	item, err := CreateItem(ctx, CatalogItem{Title: "test " + testSuffix(), Price: 5, Stock: 2, Active: true})
+	if err != nil {
+		t.Fatalf("CreateItem() error = %v", err)
+	}
+
+	login := testUser(t, ctx)
+	testCredit(t, ctx, login, 10)
+
+	red, statusCode, err := RedeemItem(ctx, login, item.ID, RedeemRequest{Quantity: 2})
+	if statusCode != http.StatusOK {
+		t.Fatalf("RedeemItem() = %d, %v", statusCode, err)
+	}
+
+	if _, err = ReverseWithdrawal(ctx, red.Order, ReverseRequest{Sum: sumOf(5)}); !errors.Is(err, ErrPartialRedemption) {
+		t.Fatalf("ReverseWithdrawal() error = %v, want %v", err, ErrPartialRedemption)
+	}
+
+	if _, err = ReverseWithdrawal(ctx, red.Order, ReverseRequest{}); err != nil {
+		t.Fatalf("ReverseWithdrawal() error = %v", err)
+	}
+	wantBalance(t, ctx, login, 10, 0)
+
+	got, err := GetItem(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("GetItem() error = %v", err)
+	}
+	if got.Stock != 2 {
+		t.Fatalf("stock = %d, want 2", got.Stock)
+	}
+}
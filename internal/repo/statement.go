@@ -0,0 +1,59 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// Statement rows around the movements of the period
+const (
+	StatementOpening = "opening_balance"
+	StatementClosing = "closing_balance"
+)
+
+// StatementRow
+type StatementRow struct {
+	Login string `json:"login" db:"username"`
+	Transaction
+}
+
+// StreamStatement calls fn for every row of the statement for the period [from, to): the opening balance,
+// the movements and the closing balance of each user, rows are read one by one and not kept in memory.
+// An empty login exports all users.
+func StreamStatement(ctx context.Context, login string, from, to time.Time, fn func(StatementRow) error) error {
+	rows, err := db.QueryxContext(ctx, feedQuery+`
+SELECT username, kind, order_id, amount, reference, created_at, balance FROM (
+	SELECT username, 'opening_balance' AS kind, '' AS order_id, 0 AS amount, '' AS reference, 
+		$3::timestamptz::timestamp AS created_at, COALESCE(SUM(amount) FILTER (WHERE created_at < $3::timestamptz), 0) AS balance, 
+		0 AS part, 0 AS seq 
+	FROM feed GROUP BY username 
+	HAVING COUNT(*) FILTER (WHERE created_at < $4::timestamptz) > 0 
+	UNION ALL 
+	SELECT username, kind, order_id, amount, reference, created_at, balance, 1, seq 
+	FROM running WHERE created_at >= $3::timestamptz AND created_at < $4::timestamptz 
+	UNION ALL 
+	SELECT username, 'closing_balance', '', 0, '', 
+		$4::timestamptz::timestamp, COALESCE(SUM(amount) FILTER (WHERE created_at < $4::timestamptz), 0), 
+		2, 0 
+	FROM feed GROUP BY username 
+	HAVING COUNT(*) FILTER (WHERE created_at < $4::timestamptz) > 0 
+) statement 
+ORDER BY username, part, created_at, kind, seq, order_id;`, login, tenantID(ctx), from, to)
+	if err != nil {
+		log.Err(err).Msg("statement query error")
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v StatementRow
+		if err = rows.StructScan(&v); err != nil {
+			log.Err(err).Msg("statement scan error")
+			return err
+		}
+		if err = fn(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
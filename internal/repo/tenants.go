@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/accrual"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/policy"
+	"github.com/lekan/gophermart/internal/resilience"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTenant owns everything created before tenants and requests that name no tenant
+const DefaultTenant = "default"
+
+// ErrBadTenant
+var ErrBadTenant = errors.New("wrong tenant")
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant is a storefront with its own loyalty program. Hosts is a comma separated list,
+// empty AccrualAddress and zero limits fall back to the deployment configuration.
+// AdminToken opens the admin API of the tenant, without it only the default tenant has one, the ADMIN_TOKEN.
+// AccrualSecret signs the callbacks of the accrual system of the tenant, empty is the deployment one.
+type Tenant struct {
+	ID                   string    `json:"id" db:"tenant_id"`
+	Name                 string    `json:"name" db:"name"`
+	Hosts                string    `json:"hosts,omitempty" db:"hosts"`
+	AdminToken           string    `json:"admin_token,omitempty" db:"admin_token"`
+	AccrualAddress       string    `json:"accrual_address,omitempty" db:"accrual_address"`
+	AccrualSecret        string    `json:"accrual_callback_secret,omitempty" db:"accrual_callback_secret"`
+	LuhnCheck            bool      `json:"luhn_check" db:"luhn_check"`
+	WithdrawalMinSum     float32   `json:"withdrawal_min_sum,omitempty" db:"withdrawal_min_sum"`
+	WithdrawalMaxSum     float32   `json:"withdrawal_max_sum,omitempty" db:"withdrawal_max_sum"`
+	WithdrawalDailyCap   float32   `json:"withdrawal_daily_cap,omitempty" db:"withdrawal_daily_cap"`
+	WithdrawalMonthlyCap float32   `json:"withdrawal_monthly_cap,omitempty" db:"withdrawal_monthly_cap"`
+	Active               bool      `json:"active" db:"active"`
+	CreatedAt            time.Time `json:"created_at" db:"created_at"`
+}
+
+// validate
+func (t Tenant) validate() error {
+	switch {
+	case !tenantIDPattern.MatchString(t.ID):
+		return fmt.Errorf("%w: id must be lower case letters, digits and dashes", ErrBadTenant)
+	case t.Name == "":
+		return fmt.Errorf("%w: name is required", ErrBadTenant)
+	case t.WithdrawalMinSum < 0 || t.WithdrawalMaxSum < 0 || t.WithdrawalDailyCap < 0 || t.WithdrawalMonthlyCap < 0:
+		return fmt.Errorf("%w: limits can not be negative", ErrBadTenant)
+	}
+	return nil
+}
+
+// tenantCache keeps the tenants in memory, every request resolves one
+var tenantCache = struct {
+	sync.RWMutex
+	byID   map[string]Tenant
+	byHost map[string]string
+}{}
+
+// LoadTenants refreshes the cache, other instances pick up changes on the next load
+func LoadTenants(ctx context.Context) error {
+	list := []Tenant{}
+	if err := db.SelectContext(ctx, &list, `SELECT * FROM tenants`); err != nil {
+		log.Err(err).Msg("load tenants error")
+		return err
+	}
+
+	byID := make(map[string]Tenant, len(list))
+	byHost := map[string]string{}
+	for _, t := range list {
+		byID[t.ID] = t
+		for _, host := range strings.Split(t.Hosts, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				byHost[host] = t.ID
+			}
+		}
+	}
+
+	tenantCache.Lock()
+	defer tenantCache.Unlock()
+	tenantCache.byID, tenantCache.byHost = byID, byHost
+	return nil
+}
+
+// ResolveTenant finds the tenant of a request: the id wins over the host, the caller passes it only when
+// it comes from a trusted proxy. A request that names neither belongs to the default tenant.
+func ResolveTenant(host string, id string) (Tenant, error) {
+	tenantCache.RLock()
+	defer tenantCache.RUnlock()
+
+	if id == "" {
+		host = strings.ToLower(host)
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+			host = host[:i]
+		}
+		id = tenantCache.byHost[host]
+	}
+	if id == "" {
+		id = DefaultTenant
+	}
+
+	t, ok := tenantCache.byID[id]
+	if !ok || !t.Active {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+type tenantKey struct{}
+
+// WithTenant scopes the repo calls made with the context
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFrom returns the tenant of the context, background jobs without one work for the default tenant
+func TenantFrom(ctx context.Context) Tenant {
+	if t, ok := ctx.Value(tenantKey{}).(Tenant); ok {
+		return t
+	}
+
+	tenantCache.RLock()
+	defer tenantCache.RUnlock()
+	if t, ok := tenantCache.byID[DefaultTenant]; ok {
+		return t
+	}
+	return Tenant{ID: DefaultTenant, LuhnCheck: true, Active: true}
+}
+
+// tenantID
+func tenantID(ctx context.Context) string {
+	return TenantFrom(ctx).ID
+}
+
+// withTenantID puts the tenant with the id into the context, for jobs that go through the data of all tenants
+func withTenantID(ctx context.Context, id string) context.Context {
+	tenantCache.RLock()
+	t, ok := tenantCache.byID[id]
+	tenantCache.RUnlock()
+	if !ok {
+		t = Tenant{ID: id, LuhnCheck: true}
+	}
+	return WithTenant(ctx, t)
+}
+
+// BelongsToTenant checks that the user of the session is registered with the tenant of the request
+func BelongsToTenant(ctx context.Context, login string) (bool, error) {
+	var ok bool
+	err := db.GetContext(ctx, &ok, `SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 AND tenant_id = $2)`, login, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("user tenant lookup error")
+	}
+	return ok, err
+}
+
+// accrualClients are the clients of tenants with their own accrual system, keyed by address
+var accrualClients = struct {
+	sync.Mutex
+	byAddress map[string]accrual.Client
+}{byAddress: map[string]accrual.Client{}}
+
+// newAccrualClient wraps the HTTP client with the breaker and the bulkhead from the configuration
+func newAccrualClient(address string) (accrual.Client, error) {
+	c := config.Get()
+	client, err := accrual.NewHTTPClient(address, c.AccrualTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return accrual.NewGuarded(client,
+		resilience.NewBreaker(c.AccrualBreakerThreshold, c.AccrualBreakerCooldown),
+		resilience.NewBulkhead(c.AccrualMaxConcurrency)), nil
+}
+
+// clientFor returns the accrual client of the tenant of the context
+func clientFor(ctx context.Context) (accrual.Client, error) {
+	address := TenantFrom(ctx).AccrualAddress
+	if address == "" {
+		return accrualClient, nil
+	}
+
+	accrualClients.Lock()
+	defer accrualClients.Unlock()
+	if client, ok := accrualClients.byAddress[address]; ok {
+		return client, nil
+	}
+	client, err := newAccrualClient(address)
+	if err != nil {
+		return nil, err
+	}
+	accrualClients.byAddress[address] = client
+	return client, nil
+}
+
+// GetTenants
+func GetTenants(ctx context.Context) ([]Tenant, error) {
+	res := []Tenant{}
+	if err := db.SelectContext(ctx, &res, `SELECT * FROM tenants ORDER BY tenant_id`); err != nil {
+		log.Err(err).Msg("get tenants error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetTenant
+func GetTenant(ctx context.Context, id string) (Tenant, error) {
+	var res Tenant
+	if err := db.GetContext(ctx, &res, `SELECT * FROM tenants WHERE tenant_id = $1`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tenant{}, ErrNotFound
+		}
+		log.Err(err).Msg("get tenant error")
+		return Tenant{}, err
+	}
+	return res, nil
+}
+
+// CreateTenant provisions a tenant, its users, orders and settings start empty
+func CreateTenant(ctx context.Context, t Tenant) (Tenant, error) {
+	if err := t.validate(); err != nil {
+		return Tenant{}, err
+	}
+
+	var res Tenant
+	err := db.GetContext(ctx, &res, `
+INSERT INTO tenants(tenant_id, name, hosts, accrual_address, luhn_check, 
+	withdrawal_min_sum, withdrawal_max_sum, withdrawal_daily_cap, withdrawal_monthly_cap, active, admin_token, 
+	accrual_callback_secret) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) 
+ON CONFLICT (tenant_id) DO NOTHING 
+RETURNING *;`, t.ID, t.Name, t.Hosts, t.AccrualAddress, t.LuhnCheck,
+		t.WithdrawalMinSum, t.WithdrawalMaxSum, t.WithdrawalDailyCap, t.WithdrawalMonthlyCap, t.Active, t.AdminToken,
+		t.AccrualSecret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tenant{}, fmt.Errorf("%w: tenant %s exists", ErrBadTenant, t.ID)
+		}
+		log.Err(err).Msg("create tenant error")
+		return Tenant{}, err
+	}
+
+	log.Info().Msgf("tenant %s is provisioned", res.ID)
+	return res, LoadTenants(ctx)
+}
+
+// UpdateTenant, the id can not change
+func UpdateTenant(ctx context.Context, id string, t Tenant) (Tenant, error) {
+	t.ID = id
+	if err := t.validate(); err != nil {
+		return Tenant{}, err
+	}
+	if id == DefaultTenant && !t.Active {
+		return Tenant{}, fmt.Errorf("%w: the default tenant can not be disabled", ErrBadTenant)
+	}
+
+	var res Tenant
+	err := db.GetContext(ctx, &res, `
+UPDATE tenants SET name = $2, hosts = $3, accrual_address = $4, luhn_check = $5, 
+	withdrawal_min_sum = $6, withdrawal_max_sum = $7, withdrawal_daily_cap = $8, withdrawal_monthly_cap = $9, active = $10, 
+	admin_token = $11, accrual_callback_secret = $12 
+WHERE tenant_id = $1 
+RETURNING *;`, t.ID, t.Name, t.Hosts, t.AccrualAddress, t.LuhnCheck,
+		t.WithdrawalMinSum, t.WithdrawalMaxSum, t.WithdrawalDailyCap, t.WithdrawalMonthlyCap, t.Active, t.AdminToken,
+		t.AccrualSecret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tenant{}, ErrNotFound
+		}
+		log.Err(err).Msg("update tenant error")
+		return Tenant{}, err
+	}
+	return res, LoadTenants(ctx)
+}
+
+// CallbackSecret verifies the accrual callbacks for the orders of the tenant, empty means there are none
+func (t Tenant) CallbackSecret() string {
+	if t.AccrualSecret != "" {
+		return t.AccrualSecret
+	}
+	return config.GetAccrualCallbackSecret()
+}
+
+// OrderTenants returns the tenants that have an order with the number, numbers are unique only within a tenant
+func OrderTenants(ctx context.Context, orderID string) ([]Tenant, error) {
+	ids := []string{}
+	if err := db.SelectContext(ctx, &ids, `SELECT tenant_id FROM orders WHERE order_id = $1`, orderID); err != nil {
+		log.Err(err).Msg("order tenants error")
+		return nil, err
+	}
+
+	res := make([]Tenant, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, TenantFrom(withTenantID(ctx, id)))
+	}
+	return res, nil
+}
+
+// withdrawalPolicy overrides the limits in force with the non-zero limits of the tenant
+func (t Tenant) withdrawalPolicy(w policy.Withdrawal) policy.Withdrawal {
+	if t.WithdrawalMinSum > 0 {
+		w.MinSum = t.WithdrawalMinSum
+	}
+	if t.WithdrawalMaxSum > 0 {
+		w.MaxSum = t.WithdrawalMaxSum
+	}
+	if t.WithdrawalDailyCap > 0 {
+		w.DailyCap = t.WithdrawalDailyCap
+	}
+	if t.WithdrawalMonthlyCap > 0 {
+		w.MonthlyCap = t.WithdrawalMonthlyCap
+	}
+	return w
+}
@@ -0,0 +1,175 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/tiers"
+	"time"
+)
+
+// LedgerTierBonus is the part of the accrual added by the tier multiplier
+const LedgerTierBonus = "tier_bonus"
+
+var tierTable tiers.Table
+
+// TierChange
+type TierChange struct {
+	OldTier   string    `json:"old_tier" db:"old_tier"`
+	NewTier   string    `json:"new_tier" db:"new_tier"`
+	Earned    float32   `json:"earned" db:"earned"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Profile
+type Profile struct {
+	Login           string       `json:"login"`
+	Tier            string       `json:"tier"`
+	Multiplier      float32      `json:"multiplier"`
+	Earned          float32      `json:"earned"`
+	NextTier        string       `json:"next_tier,omitempty"`
+	ToNextTier      float32      `json:"to_next_tier,omitempty"`
+	TierWindowHours int          `json:"tier_window_hours"`
+	TierHistory     []TierChange `json:"tier_history"`
+}
+
+// earnedPoints sums the raw accruals of the window, tier bonuses do not count
+func earnedPoints(ctx context.Context, q sqlx.QueryerContext, login string) (float32, error) {
+	var earned float32
+	err := sqlx.GetContext(ctx, q, &earned, `
+SELECT COALESCE(SUM(accrual), 0) FROM orders 
+WHERE username = $1 AND status = 'PROCESSED' AND uploaded_at > $2::timestamptz;`,
+		login, time.Now().Add(-config.Get().TierWindow))
+	if err != nil {
+		log.Err(err).Msg("earned points error")
+	}
+	return earned, err
+}
+
+// recalcTier moves the user to the tier of the points earned and records the change
+func recalcTier(ctx context.Context, q sqlx.ExtContext, login string) (string, error) {
+	var current string
+	if err := sqlx.GetContext(ctx, q, &current, `SELECT tier FROM users WHERE username = $1 FOR UPDATE`, login); err != nil {
+		log.Err(err).Msg("lock tier error")
+		return "", err
+	}
+
+	earned, err := earnedPoints(ctx, q, login)
+	if err != nil {
+		return "", err
+	}
+
+	tier := tierTable.For(earned).Name
+	if tier == current {
+		return current, nil
+	}
+
+	if _, err = q.ExecContext(ctx, `UPDATE users SET tier = $1 WHERE username = $2`, tier, login); err != nil {
+		log.Err(err).Msg("tier update error")
+		return "", err
+	}
+	_, err = q.ExecContext(ctx, `
+INSERT INTO tier_history(username, old_tier, new_tier, earned) 
+VALUES ($1, $2, $3, $4);`, login, current, tier, earned)
+	if err != nil {
+		log.Err(err).Msg("tier history error")
+		return "", err
+	}
+
+	log.Info().Msgf("%s moved from tier %q to %q", login, current, tier)
+	return tier, nil
+}
+
+// orderTier is the tier the user had before the processed order, its accrual is already counted as earned
+func orderTier(ctx context.Context, q sqlx.QueryerContext, login string, order Order) (string, error) {
+	earned, err := earnedPoints(ctx, q, login)
+	if err != nil {
+		return "", err
+	}
+	return tierTable.For(earned - order.Accrual).Name, nil
+}
+
+// applyTierBonus credits the multiplier part of a processed order on top of the accrual,
+// tier is the one the user had before the order
+func applyTierBonus(ctx context.Context, tx *sqlx.Tx, login string, order Order, tier string) error {
+	bonus := tierTable.Bonus(tier, order.Accrual)
+	if bonus > 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE orders SET tier_bonus = $1 WHERE order_id = $2 AND username = $3`, bonus, order.OrderID, login); err != nil {
+			log.Err(err).Msg("tier bonus update error")
+			return err
+		}
+		if err := postEntry(ctx, tx, login, LedgerTierBonus, bonus, order.OrderID, fmt.Sprintf("tier %s", tier)); err != nil {
+			return err
+		}
+	}
+
+	_, err := recalcTier(ctx, tx, login)
+	return err
+}
+
+// RecalculateTiers moves down the users whose points left the window
+func RecalculateTiers(ctx context.Context) error {
+	logins := []string{}
+	if err := db.SelectContext(ctx, &logins, `SELECT username FROM users`); err != nil {
+		log.Err(err).Msg("tier users error")
+		return err
+	}
+
+	for _, login := range logins {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err = recalcTier(ctx, tx, login); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProfile
+func GetProfile(ctx context.Context, login string) (Profile, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Profile{}, err
+	}
+	defer tx.Rollback()
+
+	tier, err := recalcTier(ctx, tx, login)
+	if err != nil {
+		return Profile{}, err
+	}
+	earned, err := earnedPoints(ctx, tx, login)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	res := Profile{
+		Login:           login,
+		Tier:            tier,
+		Multiplier:      tierTable.Multiplier(tier),
+		Earned:          earned,
+		TierWindowHours: int(config.Get().TierWindow.Hours()),
+		TierHistory:     []TierChange{},
+	}
+	if next, ok := tierTable.Next(tier); ok {
+		res.NextTier = next.Name
+		res.ToNextTier = next.Threshold - earned
+	}
+
+	err = tx.SelectContext(ctx, &res.TierHistory, `
+SELECT old_tier, new_tier, earned, created_at FROM tier_history 
+WHERE username = $1 
+ORDER BY created_at;`, login)
+	if err != nil {
+		log.Err(err).Msg("tier history error")
+		return Profile{}, err
+	}
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// Transaction kinds of the feed besides the ledger ones
+const (
+	TransactionAccrual    = "accrual"
+	TransactionWithdrawal = "withdrawal"
+)
+
+// Transaction is one entry of the user feed, Balance is the balance right after it
+type Transaction struct {
+	Kind      string    `json:"kind" db:"kind"`
+	Order     string    `json:"order,omitempty" db:"order_id"`
+	Amount    float32   `json:"amount" db:"amount"`
+	Reference string    `json:"reference,omitempty" db:"reference"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Balance   float32   `json:"balance" db:"balance"`
+}
+
+// TransactionFilter, zero From and To are not applied
+type TransactionFilter struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// feedQuery merges processed accruals, withdrawals and ledger entries, these are exactly the
+// sources of the balance, so the running balance of the last entry is the current one.
+// An empty login selects every user of the tenant. Every source is stamped by now() of the database,
+// the bounds are compared as timestamptz, so they mean the same moment in any time zone.
+const feedQuery = `
+WITH scope AS (
+	SELECT username FROM users WHERE tenant_id = $2 AND ($1 = '' OR username = $1) 
+), feed AS (
+	SELECT username, 'accrual' AS kind, order_id, accrual AS amount, '' AS reference, uploaded_at AS created_at, 0 AS seq 
+	FROM orders WHERE username IN (SELECT username FROM scope) AND status = 'PROCESSED' AND accrual <> 0 
+	UNION ALL 
+	SELECT username, 'withdrawal', order_id, -withdraw_sum, '', processed_at, operation_id 
+	FROM withdrawals WHERE username IN (SELECT username FROM scope) 
+	UNION ALL 
+	SELECT username, kind, order_id, amount, reference, created_at, entry_id 
+	FROM ledger WHERE username IN (SELECT username FROM scope) 
+), running AS (
+	SELECT *, SUM(amount) OVER (
+		PARTITION BY username 
+		ORDER BY created_at, kind, seq, order_id 
+		ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) AS balance 
+	FROM feed 
+) `
+
+// GetTransactions returns the chronological feed of the user with the running balance
+func GetTransactions(ctx context.Context, login string, f TransactionFilter) ([]Transaction, error) {
+	var from, to *time.Time
+	if !f.From.IsZero() {
+		from = &f.From
+	}
+	if !f.To.IsZero() {
+		to = &f.To
+	}
+
+	res := []Transaction{}
+	err := db.SelectContext(ctx, &res, feedQuery+`
+SELECT kind, order_id, amount, reference, created_at, balance FROM running 
+WHERE ($3::timestamptz IS NULL OR created_at >= $3) AND ($4::timestamptz IS NULL OR created_at < $4) 
+ORDER BY created_at, kind, seq, order_id 
+LIMIT $5 OFFSET $6;`, login, tenantID(ctx), from, to, f.Limit, f.Offset)
+	if err != nil {
+		log.Err(err).Msg("get transactions error")
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,177 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/config"
+	"github.com/lekan/gophermart/internal/fraud"
+	"github.com/omeid/pgerror"
+	"net/http"
+	"time"
+)
+
+const (
+	LedgerTransferOut = "transfer_out"
+	LedgerTransferIn  = "transfer_in"
+)
+
+// Transfer
+type Transfer struct {
+	ID        int       `json:"id" db:"transfer_id"`
+	From      string    `json:"from" db:"sender"`
+	To        string    `json:"to" db:"recipient"`
+	Sum       float32   `json:"sum" db:"amount"`
+	Key       *string   `json:"-" db:"idempotency_key"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TransferRequest
+type TransferRequest struct {
+	To  string  `json:"to"`
+	Sum float32 `json:"sum"`
+}
+
+// MakeTransfer moves points between two users in one transaction. A repeated request with the
+// same idempotency key returns the first transfer, with other parameters it is a conflict.
+func MakeTransfer(ctx context.Context, login string, key string, req *TransferRequest) (Transfer, int, error) {
+	if req.To == "" || req.Sum <= 0 {
+		return Transfer{}, http.StatusBadRequest, nil
+	}
+	if req.To == login {
+		log.Info().Msg("transfer to yourself")
+		return Transfer{}, http.StatusBadRequest, nil
+	}
+
+	c := config.Get()
+	if c.TransferMaxSum > 0 && req.Sum > c.TransferMaxSum {
+		log.Info().Msgf("transfer %v is over the limit", req.Sum)
+		return Transfer{}, http.StatusForbidden, nil
+	}
+
+	var idempotencyKey *string
+	if key != "" {
+		idempotencyKey = &key
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	if idempotencyKey != nil {
+		prev, statusCode, err := priorTransfer(ctx, tx, login, key, req)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return prev, statusCode, err
+		}
+	}
+
+	// блокируем обоих пользователей всегда в одном порядке, получатель из другого тенанта не найдётся
+	locked := []string{}
+	err = tx.SelectContext(ctx, &locked, `
+SELECT username FROM users 
+WHERE username IN ($1, $2) AND tenant_id = $3 
+ORDER BY username 
+FOR UPDATE;`, login, req.To, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("lock users error")
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+	if len(locked) != 2 {
+		log.Info().Msgf("unknown recipient %s", req.To)
+		return Transfer{}, http.StatusNotFound, nil
+	}
+
+	action, err := restriction(ctx, tx, login, fraud.OpTransfer)
+	if err != nil {
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+	if action != fraud.Allow {
+		log.Info().Msgf("flagged user %s can not transfer points", login)
+		return Transfer{}, http.StatusForbidden, ErrUnderReview
+	}
+
+	if c.TransferDailyLimit > 0 {
+		var sent float32
+		err = tx.GetContext(ctx, &sent, `
+SELECT COALESCE(SUM(amount), 0) FROM transfers 
+WHERE sender = $1 AND created_at > now() - interval '1 day';`, login)
+		if err != nil {
+			log.Err(err).Msg("daily transfers error")
+			return Transfer{}, http.StatusInternalServerError, err
+		}
+		if sent+req.Sum > c.TransferDailyLimit {
+			log.Info().Msgf("daily transfer limit of %s is exceeded", login)
+			return Transfer{}, http.StatusForbidden, nil
+		}
+	}
+
+	var t Transfer
+	err = tx.GetContext(ctx, &t, `
+INSERT INTO transfers(sender, recipient, amount, idempotency_key) 
+VALUES ($1, $2, $3, $4) 
+RETURNING *;`, login, req.To, req.Sum, idempotencyKey)
+	if err != nil {
+		if errors.Is(err, pgerror.UniqueViolation(err)) {
+			// параллельный запрос с тем же ключом успел раньше, транзакция прервана, ищем его перевод вне её
+			_ = tx.Rollback()
+			return priorTransfer(ctx, db, login, key, req)
+		}
+		log.Err(err).Msg("transfer insert error")
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+
+	takes, err := debitEntry(ctx, tx, login, LedgerTransferOut, req.Sum, "", fmt.Sprintf("transfer #%d to %s", t.ID, req.To))
+	if err != nil {
+		if errors.Is(err, ErrInsufficientFunds) {
+			return Transfer{}, http.StatusPaymentRequired, nil
+		}
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+
+	// получатель получает баллы с теми же сроками сгорания, что были у отправителя
+	err = carryEntry(ctx, tx, req.To, LedgerTransferIn, req.Sum, "", fmt.Sprintf("transfer #%d from %s", t.ID, login), takes)
+	if err != nil {
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+	return t, http.StatusOK, nil
+}
+
+// priorTransfer returns the transfer made earlier with the idempotency key, sql.ErrNoRows when there is none.
+// The same key with other parameters is a conflict.
+func priorTransfer(ctx context.Context, q sqlx.QueryerContext, login string, key string, req *TransferRequest) (Transfer, int, error) {
+	var prev Transfer
+	err := sqlx.GetContext(ctx, q, &prev, `SELECT * FROM transfers WHERE sender = $1 AND idempotency_key = $2`, login, key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Err(err).Msg("transfer lookup error")
+		}
+		return Transfer{}, http.StatusInternalServerError, err
+	}
+	if prev.To != req.To || prev.Sum != req.Sum {
+		return Transfer{}, http.StatusConflict, nil
+	}
+	return prev, http.StatusOK, nil
+}
+
+// GetTransfers returns transfers sent and received by the user
+func GetTransfers(ctx context.Context, login string) ([]Transfer, error) {
+	res := []Transfer{}
+	err := db.SelectContext(ctx, &res, `
+SELECT * FROM transfers 
+WHERE sender = $1 OR recipient = $1 
+ORDER BY created_at;`, login)
+	if err != nil {
+		log.Err(err).Msg("get transfers error")
+		return nil, err
+	}
+	return res, nil
+}
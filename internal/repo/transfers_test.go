@@ -0,0 +1,71 @@
+package repo
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestMakeTransfer(t *testing.T) {
+	ctx := testContext(t)
+
+	sender := testUser(t, ctx)
+	recipient := testUser(t, ctx)
+	testCredit(t, ctx, sender, 10)
+
+	key := "key_" + testSuffix()
+	first, statusCode, err := MakeTransfer(ctx, sender, key, &TransferRequest{To: recipient, Sum: 4})
+	if statusCode != http.StatusOK {
+		t.Fatalf("MakeTransfer() = %d, %v", statusCode, err)
+	}
+	wantBalance(t, ctx, sender, 6, 0)
+	wantBalance(t, ctx, recipient, 4, 0)
+	wantRemaining(t, ctx, sender, []float32{6})
+	wantRemaining(t, ctx, recipient, []float32{4})
+
+	again, statusCode, err := MakeTransfer(ctx, sender, key, &TransferRequest{To: recipient, Sum: 4})
+	if statusCode != http.StatusOK || again.ID != first.ID {
+		t.Fatalf("repeated MakeTransfer() = #%d %d, %v, want #%d", again.ID, statusCode, err, first.ID)
+	}
+	wantBalance(t, ctx, sender, 6, 0)
+
+	if _, statusCode, _ = MakeTransfer(ctx, sender, key, &TransferRequest{To: recipient, Sum: 5}); statusCode != http.StatusConflict {
+		t.Fatalf("MakeTransfer() with a reused key = %d, want %d", statusCode, http.StatusConflict)
+	}
+
+	if _, statusCode, _ = MakeTransfer(ctx, sender, "", &TransferRequest{To: recipient, Sum: 7}); statusCode != http.StatusPaymentRequired {
+		t.Fatalf("MakeTransfer() over the balance = %d, want %d", statusCode, http.StatusPaymentRequired)
+	}
+}
+
+func TestMakeTransfer_SameKeyRace(t *testing.T) {
+	ctx := testContext(t)
+
+	sender := testUser(t, ctx)
+	recipient := testUser(t, ctx)
+	testCredit(t, ctx, sender, 10)
+
+	const n = 5
+	key := "key_" + testSuffix()
+	ids := make([]int, n)
+	codes := make([]int, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tr, statusCode, _ := MakeTransfer(ctx, sender, key, &TransferRequest{To: recipient, Sum: 1})
+			ids[i], codes[i] = tr.ID, statusCode
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range codes {
+		if codes[i] != http.StatusOK || ids[i] != ids[0] {
+			t.Fatalf("request #%d = #%d %d, want #%d %d", i, ids[i], codes[i], ids[0], http.StatusOK)
+		}
+	}
+	wantBalance(t, ctx, sender, 9, 0)
+	wantBalance(t, ctx, recipient, 1, 0)
+}
@@ -0,0 +1,272 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lekan/gophermart/internal/codegen"
+	"github.com/lekan/gophermart/internal/policy"
+	"github.com/lib/pq"
+	"net/http"
+	"time"
+)
+
+const (
+	LedgerVoucher = "voucher"
+
+	// voucherCodeLength of 16 characters gives about 79 random bits
+	voucherCodeLength = 16
+	maxVoucherBatch   = 10000
+)
+
+// ErrBadVoucherBatch
+var ErrBadVoucherBatch = errors.New("wrong voucher batch")
+
+// ErrVoucherExhausted is returned when every use of the code is taken, unlike a code the user already redeemed
+var ErrVoucherExhausted = &policy.Violation{Code: "voucher_exhausted", Message: "the voucher has no uses left"}
+
+// VoucherBatchRequest
+type VoucherBatchRequest struct {
+	Name      string     `json:"name"`
+	Value     float32    `json:"value"`
+	Count     int        `json:"count"`
+	MaxUses   int        `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// VoucherBatch with its redemption statistics
+type VoucherBatch struct {
+	ID            int        `json:"id" db:"batch_id"`
+	Name          string     `json:"name" db:"name"`
+	Value         float32    `json:"value" db:"value"`
+	MaxUses       int        `json:"max_uses" db:"max_uses"`
+	Codes         int        `json:"codes" db:"codes"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	RedeemedCodes int        `json:"redeemed_codes" db:"redeemed_codes"`
+	Redemptions   int        `json:"redemptions" db:"redemptions"`
+	Credited      float32    `json:"credited" db:"credited"`
+}
+
+// Voucher
+type Voucher struct {
+	Code string `db:"code"`
+	Uses int    `db:"uses"`
+}
+
+// VoucherRedemption
+type VoucherRedemption struct {
+	Code      string    `json:"code" db:"code"`
+	Amount    float32   `json:"amount" db:"amount"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CreateVoucherBatch generates the codes of a new batch
+func CreateVoucherBatch(ctx context.Context, req VoucherBatchRequest) (VoucherBatch, error) {
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	switch {
+	case req.Name == "":
+		return VoucherBatch{}, fmt.Errorf("%w: name is required", ErrBadVoucherBatch)
+	case req.Value <= 0:
+		return VoucherBatch{}, fmt.Errorf("%w: value must be positive", ErrBadVoucherBatch)
+	case req.Count <= 0 || req.Count > maxVoucherBatch:
+		return VoucherBatch{}, fmt.Errorf("%w: count must be from 1 to %d", ErrBadVoucherBatch, maxVoucherBatch)
+	case req.MaxUses < 0:
+		return VoucherBatch{}, fmt.Errorf("%w: max_uses can not be negative", ErrBadVoucherBatch)
+	case req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()):
+		return VoucherBatch{}, fmt.Errorf("%w: batch is already expired", ErrBadVoucherBatch)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return VoucherBatch{}, err
+	}
+	defer tx.Rollback()
+
+	var batch VoucherBatch
+	err = tx.GetContext(ctx, &batch, `
+INSERT INTO voucher_batches(name, value, max_uses, codes, expires_at, tenant_id) 
+VALUES ($1, $2, $3, $4, $5, $6) 
+RETURNING batch_id, name, value, max_uses, codes, expires_at, created_at;`,
+		req.Name, req.Value, req.MaxUses, req.Count, req.ExpiresAt, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("voucher batch insert error")
+		return VoucherBatch{}, err
+	}
+
+	// коды вставляются одним запросом на всю пачку, совпадения почти невозможны,
+	// но повторяем для недостающих, пока не наберём нужное количество
+	for created := 0; created < req.Count; {
+		codes := make([]string, req.Count-created)
+		for i := range codes {
+			if codes[i], err = codegen.New(voucherCodeLength); err != nil {
+				return VoucherBatch{}, err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO vouchers(code, batch_id) 
+SELECT unnest($1::varchar[]), $2 
+ON CONFLICT (code) DO NOTHING;`, pq.StringArray(codes), batch.ID)
+		if err != nil {
+			log.Err(err).Msg("voucher insert error")
+			return VoucherBatch{}, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return VoucherBatch{}, err
+		}
+		created += int(n)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return VoucherBatch{}, err
+	}
+	log.Info().Msgf("voucher batch #%d of %d codes created", batch.ID, req.Count)
+	return batch, nil
+}
+
+// RedeemVoucher credits the value of the code, a user can use each code once
+func RedeemVoucher(ctx context.Context, login string, code string) (VoucherRedemption, int, error) {
+	code = codegen.Normalize(code)
+	if code == "" {
+		return VoucherRedemption{}, http.StatusBadRequest, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+	defer tx.Rollback()
+
+	var v struct {
+		BatchID   int        `db:"batch_id"`
+		Uses      int        `db:"uses"`
+		MaxUses   int        `db:"max_uses"`
+		Value     float32    `db:"value"`
+		ExpiresAt *time.Time `db:"expires_at"`
+	}
+	err = tx.GetContext(ctx, &v, `
+SELECT v.batch_id, v.uses, b.max_uses, b.value, b.expires_at 
+FROM vouchers v JOIN voucher_batches b ON b.batch_id = v.batch_id 
+WHERE v.code = $1 AND b.tenant_id = $2 
+FOR UPDATE OF v;`, code, tenantID(ctx))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Info().Msgf("unknown voucher redeemed by %s", login)
+			return VoucherRedemption{}, http.StatusNotFound, nil
+		}
+		log.Err(err).Msg("voucher lookup error")
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if v.ExpiresAt != nil && v.ExpiresAt.Before(time.Now()) {
+		return VoucherRedemption{}, http.StatusGone, nil
+	}
+
+	var redeemed bool
+	err = tx.GetContext(ctx, &redeemed, `SELECT EXISTS(SELECT 1 FROM voucher_redemptions WHERE code = $1 AND username = $2)`, code, login)
+	if err != nil {
+		log.Err(err).Msg("voucher redemption lookup error")
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+	if redeemed {
+		log.Info().Msgf("voucher is already redeemed by %s", login)
+		return VoucherRedemption{}, http.StatusConflict, nil
+	}
+	if v.Uses >= v.MaxUses {
+		return VoucherRedemption{}, http.StatusConflict, ErrVoucherExhausted
+	}
+
+	var red VoucherRedemption
+	err = tx.GetContext(ctx, &red, `
+INSERT INTO voucher_redemptions(code, username, amount) 
+VALUES ($1, $2, $3) 
+ON CONFLICT (code, username) DO NOTHING 
+RETURNING code, amount, created_at;`, code, login, v.Value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Info().Msgf("voucher is already redeemed by %s", login)
+			return VoucherRedemption{}, http.StatusConflict, nil
+		}
+		log.Err(err).Msg("voucher redemption insert error")
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE vouchers SET uses = uses + 1 WHERE code = $1`, code); err != nil {
+		log.Err(err).Msg("voucher uses update error")
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if err = postEntry(ctx, tx, login, LedgerVoucher, v.Value, "", fmt.Sprintf("voucher batch #%d", v.BatchID)); err != nil {
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return VoucherRedemption{}, http.StatusInternalServerError, err
+	}
+	return red, http.StatusOK, nil
+}
+
+const voucherBatchQuery = `
+SELECT b.batch_id, b.name, b.value, b.max_uses, b.codes, b.expires_at, b.created_at, 
+	COALESCE(s.redeemed_codes, 0) AS redeemed_codes, 
+	COALESCE(s.redemptions, 0) AS redemptions, 
+	COALESCE(s.credited, 0) AS credited 
+FROM voucher_batches b 
+LEFT JOIN (
+	SELECT v.batch_id, COUNT(DISTINCT r.code) AS redeemed_codes, COUNT(*) AS redemptions, SUM(r.amount) AS credited 
+	FROM voucher_redemptions r JOIN vouchers v ON v.code = r.code 
+	GROUP BY v.batch_id 
+) s ON s.batch_id = b.batch_id 
+WHERE b.tenant_id = $1 `
+
+// GetVoucherBatches
+func GetVoucherBatches(ctx context.Context) ([]VoucherBatch, error) {
+	res := []VoucherBatch{}
+	if err := db.SelectContext(ctx, &res, voucherBatchQuery+`ORDER BY b.created_at DESC`, tenantID(ctx)); err != nil {
+		log.Err(err).Msg("get voucher batches error")
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetVoucherBatch
+func GetVoucherBatch(ctx context.Context, id int) (VoucherBatch, error) {
+	var res VoucherBatch
+	if err := db.GetContext(ctx, &res, voucherBatchQuery+`AND b.batch_id = $2`, tenantID(ctx), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VoucherBatch{}, ErrNotFound
+		}
+		log.Err(err).Msg("get voucher batch error")
+		return VoucherBatch{}, err
+	}
+	return res, nil
+}
+
+// StreamVouchers calls fn for every code of the batch without loading the whole batch
+func StreamVouchers(ctx context.Context, batchID int, fn func(Voucher) error) error {
+	rows, err := db.QueryxContext(ctx, `
+SELECT v.code, v.uses FROM vouchers v JOIN voucher_batches b ON b.batch_id = v.batch_id 
+WHERE v.batch_id = $1 AND b.tenant_id = $2 
+ORDER BY v.code;`, batchID, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("vouchers query error")
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v Voucher
+		if err = rows.StructScan(&v); err != nil {
+			return err
+		}
+		if err = fn(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// testVoucher creates a batch of one code
+func testVoucher(t *testing.T, ctx context.Context, value float32, maxUses int) string {
+	t.Helper()
+
+	batch, err := CreateVoucherBatch(ctx, VoucherBatchRequest{Name: "test " + testSuffix(), Value: value, Count: 1, MaxUses: maxUses})
+	if err != nil {
+		t.Fatalf("CreateVoucherBatch() error = %v", err)
+	}
+
+	var code string
+	if err = db.GetContext(ctx, &code, `SELECT code FROM vouchers WHERE batch_id = $1`, batch.ID); err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestRedeemVoucher(t *testing.T) {
+	ctx := testContext(t)
+
+	code := testVoucher(t, ctx, 5, 2)
+	first := testUser(t, ctx)
+	second := testUser(t, ctx)
+	third := testUser(t, ctx)
+
+	tests := []struct {
+		name           string
+		login          string
+		code           string
+		wantStatusCode int
+		wantErr        error
+		wantCurrent    float32
+	}{
+		{
+			name:           "success test #1",
+			login:          first,
+			code:           code,
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    5,
+		},
+		{
+			name:           "redeemed by the same user",
+			login:          first,
+			code:           code,
+			wantStatusCode: http.StatusConflict,
+			wantCurrent:    5,
+		},
+		{
+			name:           "success test #2",
+			login:          second,
+			code:           code,
+			wantStatusCode: http.StatusOK,
+			wantCurrent:    5,
+		},
+		{
+			name:           "exhausted",
+			login:          third,
+			code:           code,
+			wantStatusCode: http.StatusConflict,
+			wantErr:        ErrVoucherExhausted,
+			wantCurrent:    0,
+		},
+		{
+			name:           "unknown code",
+			login:          third,
+			code:           "NOSUCHCODE",
+			wantStatusCode: http.StatusNotFound,
+			wantCurrent:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, statusCode, err := RedeemVoucher(ctx, tt.login, tt.code)
+			if statusCode != tt.wantStatusCode || err != tt.wantErr {
+				t.Fatalf("RedeemVoucher() = %d, %v, want %d, %v", statusCode, err, tt.wantStatusCode, tt.wantErr)
+			}
+			wantBalance(t, ctx, tt.login, tt.wantCurrent, 0)
+		})
+	}
+}
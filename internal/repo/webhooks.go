@@ -0,0 +1,233 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+const (
+	WebhookOrderProcessed   = "order.processed"
+	WebhookOrderInvalid     = "order.invalid"
+	WebhookWithdrawalCreate = "withdrawal.created"
+)
+
+// WebhookEventTypes is the list of events a subscription may ask for
+var WebhookEventTypes = []string{WebhookOrderProcessed, WebhookOrderInvalid, WebhookWithdrawalCreate}
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+	DeliveryCanceled  = "CANCELED"
+)
+
+// orderWebhook
+type orderWebhook struct {
+	Login string `json:"login"`
+	Order
+}
+
+// withdrawalWebhook
+type withdrawalWebhook struct {
+	Login string `json:"login"`
+	Withdrawals
+}
+
+// ErrNotFound
+var ErrNotFound = errors.New("not found")
+
+// WebhookSubscription
+type WebhookSubscription struct {
+	ID         int            `json:"id" db:"subscription_id"`
+	URL        string         `json:"url" db:"url"`
+	Secret     string         `json:"secret,omitempty" db:"secret"`
+	EventTypes pq.StringArray `json:"event_types" db:"event_types"`
+	Active     bool           `json:"active" db:"active"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery
+type WebhookDelivery struct {
+	ID             int64           `json:"id" db:"delivery_id"`
+	SubscriptionID int             `json:"subscription_id" db:"subscription_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	ResponseCode   int             `json:"response_code" db:"response_code"`
+	LastError      string          `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+	URL            string          `json:"-" db:"url"`
+	Secret         string          `json:"-" db:"secret"`
+}
+
+// CreateWebhook
+func CreateWebhook(ctx context.Context, sub *WebhookSubscription) error {
+	err := db.GetContext(ctx, sub, `
+INSERT INTO webhook_subscriptions(url, secret, event_types, tenant_id) 
+VALUES ($1, $2, $3, $4) 
+RETURNING subscription_id, url, secret, event_types, active, created_at;`, sub.URL, sub.Secret, sub.EventTypes, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("create webhook error")
+		return err
+	}
+	return nil
+}
+
+// GetWebhooks
+func GetWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	subs := []WebhookSubscription{}
+	err := db.SelectContext(ctx, &subs, `
+SELECT subscription_id, url, event_types, active, created_at 
+FROM webhook_subscriptions 
+WHERE tenant_id = $1 
+ORDER BY subscription_id;`, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get webhooks error")
+		return nil, err
+	}
+	return subs, nil
+}
+
+// DisableWebhook stops the deliveries, the queued ones are canceled, the delivery log is kept
+func DisableWebhook(ctx context.Context, id int) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE webhook_subscriptions SET active = false WHERE subscription_id = $1 AND tenant_id = $2`,
+		id, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("disable webhook error")
+		return err
+	}
+	if err = mustAffect(res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE webhook_deliveries SET status = $2, last_error = 'subscription disabled' 
+WHERE subscription_id = $1 AND status = $3;`, id, DeliveryCanceled, DeliveryPending)
+	if err != nil {
+		log.Err(err).Msg("cancel webhook deliveries error")
+		return err
+	}
+	return tx.Commit()
+}
+
+// enqueueWebhook creates a delivery for every active subscription of the tenant to the event
+func enqueueWebhook(ctx context.Context, q sqlx.ExecerContext, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, `
+INSERT INTO webhook_deliveries(subscription_id, event_type, payload) 
+SELECT subscription_id, $1, $2 
+FROM webhook_subscriptions 
+WHERE active AND $1 = ANY(event_types) AND tenant_id = $3;`, eventType, string(data), tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("enqueue webhook error")
+		return err
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries takes due deliveries and hides them from other instances for the lease time
+func ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := db.SelectContext(ctx, &deliveries, `
+WITH d AS (
+	UPDATE webhook_deliveries 
+	SET next_attempt_at = now() + $2 * interval '1 second' 
+	WHERE delivery_id IN (
+		SELECT d.delivery_id FROM webhook_deliveries d 
+		JOIN webhook_subscriptions s ON s.subscription_id = d.subscription_id 
+		WHERE d.status = $3 AND d.next_attempt_at <= now() AND s.active 
+		ORDER BY d.delivery_id 
+		LIMIT $1 
+		FOR UPDATE OF d SKIP LOCKED
+	) 
+	RETURNING delivery_id, subscription_id, event_type, payload, status, attempts, next_attempt_at, response_code, last_error, created_at
+)
+SELECT d.*, s.url, s.secret 
+FROM d JOIN webhook_subscriptions s ON s.subscription_id = d.subscription_id 
+ORDER BY d.delivery_id;`, limit, lease.Seconds(), DeliveryPending)
+	if err != nil {
+		log.Err(err).Msg("claim webhook deliveries error")
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt stores the result of a delivery attempt
+func RecordWebhookAttempt(ctx context.Context, id int64, status string, responseCode int, lastError string, nextAttemptAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+UPDATE webhook_deliveries 
+SET status = $2, 
+    attempts = attempts + 1, 
+    response_code = $3, 
+    last_error = $4, 
+    next_attempt_at = $5, 
+    delivered_at = CASE WHEN $2 = 'DELIVERED' THEN now() END 
+WHERE delivery_id = $1;`, id, status, responseCode, lastError, nextAttemptAt)
+	if err != nil {
+		log.Err(err).Msg("record webhook attempt error")
+		return err
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the delivery log, newest first
+func GetWebhookDeliveries(ctx context.Context, subscriptionID int, status string, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := db.SelectContext(ctx, &deliveries, `
+SELECT d.delivery_id, d.subscription_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, 
+	d.response_code, d.last_error, d.created_at, d.delivered_at 
+FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.subscription_id = d.subscription_id 
+WHERE ($1 = 0 OR d.subscription_id = $1) AND ($2 = '' OR d.status = $2) AND s.tenant_id = $4 
+ORDER BY d.delivery_id DESC 
+LIMIT $3;`, subscriptionID, status, limit, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("get webhook deliveries error")
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ReplayWebhookDelivery puts a delivery back to the queue, deliveries of a disabled subscription are not replayed
+func ReplayWebhookDelivery(ctx context.Context, id int64) error {
+	res, err := db.ExecContext(ctx, `
+UPDATE webhook_deliveries 
+SET status = $2, attempts = 0, next_attempt_at = now(), delivered_at = NULL 
+WHERE delivery_id = $1 AND subscription_id IN (SELECT subscription_id FROM webhook_subscriptions WHERE tenant_id = $3 AND active);`,
+		id, DeliveryPending, tenantID(ctx))
+
+	if err != nil {
+		log.Err(err).Msg("replay webhook delivery error")
+		return err
+	}
+	return mustAffect(res)
+}
+
+// mustAffect turns an update of nothing into ErrNotFound
+func mustAffect(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lekan/gophermart/internal/policy"
+	"net/http"
+	"time"
+)
+
+// ErrOrderPaid is returned when points were already withdrawn for the order
+var ErrOrderPaid = &policy.Violation{Code: "order_already_paid", Message: "the order is already paid with points"}
+
+// checkWithdrawalPolicy applies the withdrawal rules in force with the limits of the tenant, the user must be locked.
+// A fully reversed withdrawal doesn't keep the order paid. Withdrawals made before the rule are marked legacy,
+// they may repeat an order and are left out of the unique index but still count here.
+func checkWithdrawalPolicy(ctx context.Context, tx *sqlx.Tx, login string, order string, withdraw float32) (int, error) {
+	var paid bool
+	err := tx.GetContext(ctx, &paid, `
+SELECT EXISTS(SELECT 1 FROM withdrawals WHERE order_id = $1 AND tenant_id = $2 AND reversed < withdraw_sum)`,
+		order, tenantID(ctx))
+	if err != nil {
+		log.Err(err).Msg("paid order lookup error")
+		return http.StatusInternalServerError, err
+	}
+	if paid {
+		log.Info().Msgf("order %s is already paid", order)
+		return http.StatusConflict, ErrOrderPaid
+	}
+
+	var registeredAt sql.NullTime
+	if err := tx.GetContext(ctx, &registeredAt, `SELECT registered_at FROM users WHERE username = $1`, login); err != nil {
+		log.Err(err).Msg("registration date error")
+		return http.StatusInternalServerError, err
+	}
+
+	var usage struct {
+		Daily   float32 `db:"daily"`
+		Monthly float32 `db:"monthly"`
+	}
+	err = tx.GetContext(ctx, &usage, `
+SELECT 
+	COALESCE(SUM(withdraw_sum - reversed) FILTER (WHERE processed_at >= date_trunc('day', now())), 0) AS daily, 
+	COALESCE(SUM(withdraw_sum - reversed) FILTER (WHERE processed_at >= date_trunc('month', now())), 0) AS monthly 
+FROM withdrawals 
+WHERE username = $1;`, login)
+	if err != nil {
+		log.Err(err).Msg("withdrawal usage error")
+		return http.StatusInternalServerError, err
+	}
+
+	err = TenantFrom(ctx).withdrawalPolicy(policy.Get().Withdrawal).Check(policy.Usage{
+		Daily:        usage.Daily,
+		Monthly:      usage.Monthly,
+		RegisteredAt: registeredAt.Time,
+		Now:          time.Now(),
+	}, withdraw)
+	var v *policy.Violation
+	if errors.As(err, &v) {
+		log.Info().Msgf("withdrawal of %s breaks %s", login, v.Code)
+		return http.StatusForbidden, err
+	}
+	return http.StatusOK, nil
+}
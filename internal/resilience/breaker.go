@@ -0,0 +1,148 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned while the breaker doesn't let calls through
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerStats
+type BreakerStats struct {
+	State     State      `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	Rejected  int64      `json:"rejected"`
+	Threshold int        `json:"threshold"`
+	Cooldown  string     `json:"cooldown"`
+}
+
+// Breaker opens after threshold consecutive failures, after the cooldown
+// it lets one probe call through and closes again if the probe succeeds
+type Breaker struct {
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	rejected  int64
+	threshold int
+	cooldown  time.Duration
+
+	now func() time.Time
+}
+
+// NewBreaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow must be called before the call, a nil error means the call may go on
+// and its result must be reported with Success, Failure or Cancel
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+		b.probing = false
+	}
+
+	switch b.state {
+	case Open:
+		b.rejected++
+		return ErrOpen
+	case HalfOpen:
+		if b.probing {
+			b.rejected++
+			return ErrOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Success
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = Closed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.state = Open
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
+
+// Cancel reports a call that ended without an answer, it counts neither way, a probe is given back
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// Stats
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BreakerStats{
+		State:     b.state,
+		Failures:  b.failures,
+		Rejected:  b.rejected,
+		Threshold: b.threshold,
+		Cooldown:  b.cooldown.String(),
+	}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
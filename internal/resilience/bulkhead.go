@@ -0,0 +1,55 @@
+package resilience
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// BulkheadStats
+type BulkheadStats struct {
+	InFlight int64 `json:"in_flight"`
+	Capacity int   `json:"capacity"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Bulkhead bounds the number of concurrent calls
+type Bulkhead struct {
+	slots    chan struct{}
+	inFlight int64
+	rejected int64
+}
+
+// NewBulkhead
+func NewBulkhead(capacity int) *Bulkhead {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Bulkhead{slots: make(chan struct{}, capacity)}
+}
+
+// Acquire waits for a free slot until ctx is done
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		atomic.AddInt64(&b.inFlight, 1)
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&b.rejected, 1)
+		return ctx.Err()
+	}
+}
+
+// Release
+func (b *Bulkhead) Release() {
+	atomic.AddInt64(&b.inFlight, -1)
+	<-b.slots
+}
+
+// Stats
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		InFlight: atomic.LoadInt64(&b.inFlight),
+		Capacity: cap(b.slots),
+		Rejected: atomic.LoadInt64(&b.rejected),
+	}
+}
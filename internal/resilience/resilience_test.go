@@ -0,0 +1,147 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		after   time.Duration
+		fail    bool
+		wantErr bool
+		want    State
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "stays closed",
+			steps: []step{
+				{fail: true, want: Closed},
+				{fail: false, want: Closed},
+				{fail: true, want: Closed},
+				{fail: true, want: Closed},
+			},
+		},
+		{
+			name: "opens after threshold",
+			steps: []step{
+				{fail: true, want: Closed},
+				{fail: true, want: Closed},
+				{fail: true, want: Open},
+				{wantErr: true, want: Open},
+			},
+		},
+		{
+			name: "probe closes",
+			steps: []step{
+				{fail: true, want: Closed},
+				{fail: true, want: Closed},
+				{fail: true, want: Open},
+				{after: time.Minute, fail: false, want: Closed},
+				{after: time.Minute, fail: true, want: Closed},
+			},
+		},
+		{
+			name: "failed probe opens again",
+			steps: []step{
+				{fail: true, want: Closed},
+				{fail: true, want: Closed},
+				{fail: true, want: Open},
+				{after: time.Minute, fail: true, want: Open},
+				{after: time.Minute + time.Second, wantErr: true, want: Open},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			now := start
+			b := NewBreaker(3, 30*time.Second)
+			b.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = start.Add(s.after)
+				err := b.Allow()
+				if (err != nil) != s.wantErr {
+					t.Fatalf("step %d: Allow() error = %v, wantErr %v", i, err, s.wantErr)
+				}
+				if err == nil {
+					if s.fail {
+						b.Failure()
+					} else {
+						b.Success()
+					}
+				}
+				if got := b.Stats().State; got != s.want {
+					t.Errorf("step %d: state = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreaker_SingleProbe(t *testing.T) {
+	b := NewBreaker(1, 0)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	b.Failure()
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe Allow() error = %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("second probe Allow() error = %v, want %v", err, ErrOpen)
+	}
+}
+
+func TestBreaker_Cancel(t *testing.T) {
+	b := NewBreaker(1, 0)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	b.Failure()
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe Allow() error = %v", err)
+	}
+	b.Cancel()
+	if err := b.Allow(); err != nil {
+		t.Errorf("Allow() after a canceled probe error = %v", err)
+	}
+	if b.Stats().State != HalfOpen {
+		t.Errorf("state = %v, want half-open", b.Stats().State)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	b := NewBulkhead(2)
+
+	if err := b.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if err := b.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Acquire(ctx); err == nil {
+		t.Errorf("Acquire() over capacity must fail")
+	}
+
+	b.Release()
+	if err := b.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after Release() error = %v", err)
+	}
+
+	stats := b.Stats()
+	if stats.InFlight != 2 || stats.Capacity != 2 || stats.Rejected != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
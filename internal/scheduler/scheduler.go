@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/lekan/gophermart/internal/logger"
+	"time"
+)
+
+var log = logger.New()
+
+// Every runs the job right away and then every interval until ctx is done
+func Every(ctx context.Context, interval time.Duration, name string, job func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Info().Msgf("%s job is scheduled every %s", name, interval)
+
+	for {
+		if err := job(ctx); err != nil {
+			log.Err(err).Msgf("%s job error", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
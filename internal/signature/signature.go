@@ -0,0 +1,27 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const prefix = "sha256="
+
+// Sign returns the HMAC-SHA256 of "timestamp.body" in the form sha256=<hex>
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify
+func Verify(secret string, timestamp string, body []byte, sign string) bool {
+	if !strings.HasPrefix(sign, prefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(sign))
+}
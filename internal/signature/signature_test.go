@@ -0,0 +1,65 @@
+package signature
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	type args struct {
+		secret    string
+		timestamp string
+		body      []byte
+		sign      string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "success test #1",
+			args: args{
+				secret:    "secret",
+				timestamp: "1634502166",
+				body:      []byte(`{"order":"12345678903"}`),
+				sign:      Sign("secret", "1634502166", []byte(`{"order":"12345678903"}`)),
+			},
+			want: true,
+		},
+		{
+			name: "fail test #1",
+			args: args{
+				secret:    "secret",
+				timestamp: "1634502167",
+				body:      []byte(`{"order":"12345678903"}`),
+				sign:      Sign("secret", "1634502166", []byte(`{"order":"12345678903"}`)),
+			},
+			want: false,
+		},
+		{
+			name: "fail test #2",
+			args: args{
+				secret:    "other",
+				timestamp: "1634502166",
+				body:      []byte(`{"order":"12345678903"}`),
+				sign:      Sign("secret", "1634502166", []byte(`{"order":"12345678903"}`)),
+			},
+			want: false,
+		},
+		{
+			name: "fail test #3",
+			args: args{
+				secret:    "secret",
+				timestamp: "1634502166",
+				body:      []byte(`{"order":"12345678903"}`),
+				sign:      "",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.args.secret, tt.args.timestamp, tt.args.body, tt.args.sign); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
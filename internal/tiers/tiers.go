@@ -0,0 +1,99 @@
+// Package tiers computes loyalty tiers from the points earned over a window
+package tiers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tier, Threshold is the minimum of points earned to get it
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  float32 `json:"threshold"`
+	Multiplier float32 `json:"multiplier"`
+}
+
+// Table is a list of tiers sorted by threshold, the first threshold is zero
+type Table []Tier
+
+// Parse reads tiers in the form "bronze:0:1,silver:1000:1.1,gold:5000:1.25"
+func Parse(spec string) (Table, error) {
+	var t Table
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("wrong tier %q", item)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("wrong threshold of tier %q", item)
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil || multiplier < 1 {
+			return nil, fmt.Errorf("wrong multiplier of tier %q", item)
+		}
+
+		t = append(t, Tier{Name: parts[0], Threshold: float32(threshold), Multiplier: float32(multiplier)})
+	}
+
+	if len(t) == 0 {
+		return nil, errors.New("no tiers")
+	}
+
+	sort.Slice(t, func(i, j int) bool {
+		return t[i].Threshold < t[j].Threshold
+	})
+	if t[0].Threshold != 0 {
+		return nil, errors.New("the lowest tier must start at zero")
+	}
+	for i := 1; i < len(t); i++ {
+		if t[i].Threshold == t[i-1].Threshold {
+			return nil, fmt.Errorf("tiers %s and %s have the same threshold", t[i-1].Name, t[i].Name)
+		}
+	}
+	return t, nil
+}
+
+// For returns the tier for the points earned
+func (t Table) For(earned float32) Tier {
+	res := t[0]
+	for _, v := range t {
+		if earned >= v.Threshold {
+			res = v
+		}
+	}
+	return res
+}
+
+// Next returns the tier above the given one
+func (t Table) Next(name string) (Tier, bool) {
+	for i, v := range t {
+		if v.Name == name && i+1 < len(t) {
+			return t[i+1], true
+		}
+	}
+	return Tier{}, false
+}
+
+// Multiplier of the tier, unknown tiers get no bonus
+func (t Table) Multiplier(name string) float32 {
+	for _, v := range t {
+		if v.Name == name {
+			return v.Multiplier
+		}
+	}
+	return 1
+}
+
+// Bonus is what the tier adds on top of the accrual
+func (t Table) Bonus(name string, accrual float32) float32 {
+	return accrual * (t.Multiplier(name) - 1)
+}
@@ -0,0 +1,107 @@
+package tiers
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success test #1",
+			spec: "bronze:0:1,silver:1000:1.1,gold:5000:1.25",
+			want: []string{"bronze", "silver", "gold"},
+		},
+		{
+			name: "success test #2",
+			spec: " gold:5000:1.25, bronze:0:1 ,silver:1000:1.1,",
+			want: []string{"bronze", "silver", "gold"},
+		},
+		{
+			name:    "no zero tier",
+			spec:    "silver:1000:1.1,gold:5000:1.25",
+			wantErr: true,
+		},
+		{
+			name:    "same threshold",
+			spec:    "bronze:0:1,silver:1000:1.1,gold:1000:1.25",
+			wantErr: true,
+		},
+		{
+			name:    "multiplier below one",
+			spec:    "bronze:0:0.5",
+			wantErr: true,
+		},
+		{
+			name:    "wrong format",
+			spec:    "bronze:0",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			spec:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Parse() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Name != tt.want[i] {
+					t.Errorf("Parse()[%d] = %s, want %s", i, got[i].Name, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	table, err := Parse("bronze:0:1,silver:1000:1.1,gold:5000:1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		earned    float32
+		want      string
+		next      string
+		bonusOf   float32
+		wantBonus float32
+	}{
+		{name: "nothing earned", earned: 0, want: "bronze", next: "silver", bonusOf: 100, wantBonus: 0},
+		{name: "below silver", earned: 999.99, want: "bronze", next: "silver", bonusOf: 100, wantBonus: 0},
+		{name: "exactly silver", earned: 1000, want: "silver", next: "gold", bonusOf: 100, wantBonus: 10},
+		{name: "gold", earned: 100000, want: "gold", bonusOf: 100, wantBonus: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := table.For(tt.earned)
+			if got.Name != tt.want {
+				t.Errorf("For() = %s, want %s", got.Name, tt.want)
+			}
+			next, ok := table.Next(got.Name)
+			if ok != (tt.next != "") || next.Name != tt.next {
+				t.Errorf("Next() = %s, want %s", next.Name, tt.next)
+			}
+			bonus := table.Bonus(got.Name, tt.bonusOf)
+			if d := bonus - tt.wantBonus; d > 0.001 || d < -0.001 {
+				t.Errorf("Bonus() = %v, want %v", bonus, tt.wantBonus)
+			}
+		})
+	}
+
+	if m := table.Multiplier("platinum"); m != 1 {
+		t.Errorf("Multiplier() of unknown tier = %v, want 1", m)
+	}
+}
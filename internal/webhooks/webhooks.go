@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/lekan/gophermart/internal/logger"
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/signature"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var log = logger.New()
+
+const (
+	batchSize    = 20
+	sendTimeout  = 10 * time.Second
+	leaseMargin  = 30 * time.Second
+	maxAttempts  = 8
+	firstBackoff = 10 * time.Second
+	maxBackoff   = time.Hour
+)
+
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+)
+
+var client = &http.Client{Timeout: sendTimeout}
+
+// Envelope is the body of every webhook request
+type Envelope struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Send posts the signed delivery to the subscriber, any status except 2xx is an error
+func Send(ctx context.Context, client *http.Client, d repo.WebhookDelivery, now time.Time) (int, error) {
+	body, err := json.Marshal(Envelope{
+		ID:        d.ID,
+		Event:     d.EventType,
+		CreatedAt: d.CreatedAt,
+		Data:      d.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, signature.Sign(d.Secret, timestamp, body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook receiver answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Backoff returns the delay before the next attempt, it doubles after every failure
+func Backoff(attempts int) time.Duration {
+	delay := firstBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// queue of the deliveries, claimed deliveries are not given to anyone else until the lease runs out
+type queue interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]repo.WebhookDelivery, error)
+	Record(ctx context.Context, id int64, status string, responseCode int, lastError string, nextAttemptAt time.Time) error
+}
+
+// repoQueue is the queue in the database
+type repoQueue struct{}
+
+func (repoQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]repo.WebhookDelivery, error) {
+	return repo.ClaimWebhookDeliveries(ctx, limit, lease)
+}
+
+func (repoQueue) Record(ctx context.Context, id int64, status string, responseCode int, lastError string, nextAttemptAt time.Time) error {
+	return repo.RecordWebhookAttempt(ctx, id, status, responseCode, lastError, nextAttemptAt)
+}
+
+// dispatcher sends the batch one delivery after another
+type dispatcher struct {
+	queue       queue
+	client      *http.Client
+	batchSize   int
+	sendTimeout time.Duration
+	leaseMargin time.Duration
+}
+
+// lease covers the whole batch sent at the slowest, so no other instance takes it meanwhile
+func (d dispatcher) lease() time.Duration {
+	return time.Duration(d.batchSize)*d.sendTimeout + d.leaseMargin
+}
+
+// run sends the due deliveries, the sending stops before the lease runs out
+// and the deliveries left are sent again after it
+func (d dispatcher) run(ctx context.Context) error {
+	deliveries, err := d.queue.Claim(ctx, d.batchSize, d.lease())
+	if err != nil {
+		return err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, time.Duration(d.batchSize)*d.sendTimeout)
+	defer cancel()
+
+	for i, v := range deliveries {
+		if sendCtx.Err() != nil {
+			log.Info().Msgf("%d webhook deliveries are left to the next lease", len(deliveries)-i)
+			return nil
+		}
+
+		now := time.Now()
+		code, errSend := Send(sendCtx, d.client, v, now)
+
+		status := repo.DeliveryDelivered
+		lastError := ""
+		next := now
+		if errSend != nil {
+			log.Err(errSend).Msgf("webhook delivery %d error", v.ID)
+			lastError = errSend.Error()
+			status = repo.DeliveryPending
+			next = now.Add(Backoff(v.Attempts + 1))
+			if v.Attempts+1 >= maxAttempts {
+				status = repo.DeliveryFailed
+			}
+		}
+
+		if err = d.queue.Record(ctx, v.ID, status, code, lastError, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var defaultDispatcher = dispatcher{
+	queue:       repoQueue{},
+	client:      client,
+	batchSize:   batchSize,
+	sendTimeout: sendTimeout,
+	leaseMargin: leaseMargin,
+}
+
+// Dispatch sends the due deliveries from the queue
+func Dispatch(ctx context.Context) error {
+	return defaultDispatcher.run(ctx)
+}
@@ -0,0 +1,203 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lekan/gophermart/internal/repo"
+	"github.com/lekan/gophermart/internal/signature"
+)
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{
+			name:       "success test #1",
+			statusCode: http.StatusOK,
+			wantErr:    false,
+		},
+		{
+			name:       "success test #2",
+			statusCode: http.StatusNoContent,
+			wantErr:    false,
+		},
+		{
+			name:       "fail test #1",
+			statusCode: http.StatusInternalServerError,
+			wantErr:    true,
+		},
+		{
+			name:       "fail test #2",
+			statusCode: http.StatusGone,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Envelope
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if !signature.Verify("secret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+					t.Errorf("wrong signature %s", r.Header.Get(HeaderSignature))
+				}
+				if r.Header.Get(HeaderEvent) != repo.WebhookOrderProcessed {
+					t.Errorf("wrong event header %s", r.Header.Get(HeaderEvent))
+				}
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Errorf("wrong body: %v", err)
+				}
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer receiver.Close()
+
+			d := repo.WebhookDelivery{
+				ID:        42,
+				EventType: repo.WebhookOrderProcessed,
+				Payload:   json.RawMessage(`{"login":"user","order":"12345678903","status":"PROCESSED","accrual":500}`),
+				URL:       receiver.URL,
+				Secret:    "secret",
+			}
+
+			code, err := Send(context.Background(), receiver.Client(), d, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if code != tt.statusCode {
+				t.Errorf("Send() = %v, want %v", code, tt.statusCode)
+			}
+			if got.ID != d.ID || string(got.Data) != string(d.Payload) {
+				t.Errorf("receiver got %+v", got)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{
+			name:     "first attempt",
+			attempts: 1,
+			want:     firstBackoff,
+		},
+		{
+			name:     "third attempt",
+			attempts: 3,
+			want:     4 * firstBackoff,
+		},
+		{
+			name:     "capped",
+			attempts: 20,
+			want:     maxBackoff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Backoff(tt.attempts); got != tt.want {
+				t.Errorf("Backoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// memQueue leases the deliveries like the table does
+type memQueue struct {
+	mu         sync.Mutex
+	deliveries []repo.WebhookDelivery
+	leasedTill map[int64]time.Time
+	claims     map[int64]int
+}
+
+func (q *memQueue) Claim(ctx context.Context, limit int, lease time.Duration) ([]repo.WebhookDelivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := []repo.WebhookDelivery{}
+	now := time.Now()
+	for _, d := range q.deliveries {
+		if len(res) == limit {
+			break
+		}
+		if d.Status != repo.DeliveryPending || q.leasedTill[d.ID].After(now) {
+			continue
+		}
+		q.leasedTill[d.ID] = now.Add(lease)
+		q.claims[d.ID]++
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+func (q *memQueue) Record(ctx context.Context, id int64, status string, responseCode int, lastError string, nextAttemptAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.deliveries {
+		if q.deliveries[i].ID == id {
+			q.deliveries[i].Status = status
+			q.leasedTill[id] = nextAttemptAt
+		}
+	}
+	return nil
+}
+
+func TestDispatch_SlowReceiver(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		mu.Lock()
+		received[r.Header.Get(HeaderDelivery)]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	q := &memQueue{leasedTill: map[int64]time.Time{}, claims: map[int64]int{}}
+	for i := int64(1); i <= 3; i++ {
+		q.deliveries = append(q.deliveries, repo.WebhookDelivery{ID: i, Status: repo.DeliveryPending, URL: server.URL, Payload: json.RawMessage(`{}`)})
+	}
+	d := dispatcher{queue: q, client: server.Client(), batchSize: 3, sendTimeout: 50 * time.Millisecond, leaseMargin: 10 * time.Millisecond}
+
+	done := make(chan error)
+	go func() {
+		done <- d.run(context.Background())
+	}()
+
+	// другой экземпляр опрашивает очередь, пока первый отправляет медленному получателю
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			running = false
+		case <-time.After(5 * time.Millisecond):
+			other, _ := q.Claim(context.Background(), 3, d.lease())
+			if len(other) != 0 {
+				t.Fatalf("delivery %d is claimed again while being sent", other[0].ID)
+			}
+		}
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if q.claims[i] != 1 {
+			t.Errorf("delivery %d claimed %d times, want 1", i, q.claims[i])
+		}
+		if got := received[strconv.FormatInt(i, 10)]; got != 1 {
+			t.Errorf("delivery %d received %d times, want 1", i, got)
+		}
+	}
+}